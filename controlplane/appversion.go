@@ -0,0 +1,238 @@
+package controlplane
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PlatformDefault is the key of the store URL for platforms without their own
+	PlatformDefault = "default"
+)
+
+var (
+	// defaultAppVersionHeaders are where we look for the app platform and version, unless a match says otherwise
+	defaultAppVersionHeaders = []string{"X-H-App-Version", "X-H-Platform", "User-Agent"}
+
+	// appVersionRegex finds the first version in a header, like 3.1 or 3.1.4 in Hailo/3.1.4 (iOS 9.2)
+	appVersionRegex = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+	// appPlatformRegex finds the platform in a header
+	appPlatformRegex = regexp.MustCompile(`(?i)\b(ios|iphone|ipad|android|windows)\b`)
+	// appPlatforms maps what we find in headers to the platforms rules refer to
+	appPlatforms = map[string]string{
+		"ios":     "ios",
+		"iphone":  "ios",
+		"ipad":    "ios",
+		"android": "android",
+		"windows": "windows",
+	}
+
+	// versionConstraintRegex parses a version constraint, like >= 3.2 or <3.2.0
+	versionConstraintRegex = regexp.MustCompile(`^(<=|>=|!=|=|<|>)?\s*v?(\d+)(?:\.(\d+))?(?:\.(\d+))?$`)
+)
+
+// Version is a semantic version; missing minor or patch numbers are 0
+type Version [3]int
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// compare returns -1, 0 or 1 if v is less than, equal to or greater than other
+func (v Version) compare(other Version) int {
+	for i := range v {
+		switch {
+		case v[i] < other[i]:
+			return -1
+		case v[i] > other[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// AppVersion is the platform and version of a client app, as parsed from request headers
+type AppVersion struct {
+	Platform   string  // Platform is ios, android or windows, or blank if we couldn't tell
+	Version    Version // Version of the app, only valid if HasVersion
+	HasVersion bool    // HasVersion is whether we found a version
+}
+
+// ParseAppVersion works out the platform and version of the client app from the given headers, in order: the first
+// header with a version gives us the version, and the first with a recognisable platform gives us the platform
+func ParseAppVersion(header func(name string) string, headers []string) AppVersion {
+	var av AppVersion
+	for _, name := range headers {
+		v := header(name)
+		if v == "" {
+			continue
+		}
+		if av.Platform == "" {
+			if m := appPlatformRegex.FindStringSubmatch(v); m != nil {
+				av.Platform = appPlatforms[strings.ToLower(m[1])]
+			}
+		}
+		if !av.HasVersion {
+			if m := appVersionRegex.FindStringSubmatch(v); m != nil {
+				av.Version, av.HasVersion = parseVersionParts(m[1:]), true
+			}
+		}
+		if av.Platform != "" && av.HasVersion {
+			break
+		}
+	}
+	return av
+}
+
+func parseVersionParts(parts []string) Version {
+	var v Version
+	for i := 0; i < len(v) && i < len(parts); i++ {
+		v[i], _ = strconv.Atoi(parts[i]) // blank parts are 0
+	}
+	return v
+}
+
+// versionConstraint is a compiled version constraint, like < 3.2.0
+type versionConstraint struct {
+	op      string
+	version Version
+}
+
+func (c versionConstraint) allows(v Version) bool {
+	cmp := v.compare(c.version)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// compileAppVersion validates the app version match, if any, parsing its version constraints
+func (m *Match) compileAppVersion() error {
+	av := m.AppVersion
+	if av == nil {
+		return nil
+	}
+	av.platforms, av.constraints = nil, nil
+
+	if len(av.Platform) == 0 && len(av.Version) == 0 {
+		return fmt.Errorf("App version match must have a platform or a version")
+	}
+	if len(av.Platform) > 0 {
+		av.platforms = make(map[string]bool)
+		for _, p := range strings.Split(av.Platform, ",") {
+			if p = strings.ToLower(strings.TrimSpace(p)); appPlatforms[p] != p {
+				return fmt.Errorf("Invalid app platform %q; must be ios, android or windows", p)
+			}
+			av.platforms[p] = true
+		}
+	}
+	for _, h := range av.Headers {
+		if len(h) == 0 {
+			return fmt.Errorf("App version headers must have names")
+		}
+	}
+
+	if len(av.Version) > 0 {
+		for _, s := range strings.Split(av.Version, ",") {
+			parts := versionConstraintRegex.FindStringSubmatch(strings.TrimSpace(s))
+			if parts == nil {
+				return fmt.Errorf("Invalid app version constraint %q; must be like < 3.2.0", s)
+			}
+			av.constraints = append(av.constraints, versionConstraint{
+				op:      parts[1],
+				version: parseVersionParts(parts[2:]),
+			})
+		}
+	}
+
+	return nil
+}
+
+// headers returns the headers to find the app platform and version in
+func (av *AppVersionMatch) headers() []string {
+	if av == nil || len(av.Headers) == 0 {
+		return defaultAppVersionHeaders
+	}
+	return av.Headers
+}
+
+// matches tests if the app making a request is on one of the platforms, with a version within every constraint. If
+// we need a version and can't find one, it doesn't match
+func (av *AppVersionMatch) matches(ext Extractor) bool {
+	v := ParseAppVersion(ext.Header, av.headers())
+	if len(av.Platform) > 0 && !av.platforms[v.Platform] {
+		return false
+	}
+	if len(av.Version) > 0 {
+		if !v.HasVersion || len(av.constraints) == 0 {
+			return false
+		}
+		for _, c := range av.constraints {
+			if !c.allows(v.Version) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sameAs tells us if two app version matches are the same
+func (av *AppVersionMatch) sameAs(other *AppVersionMatch) bool {
+	if av == nil || other == nil {
+		return av == other
+	}
+	return av.Platform == other.Platform && av.Version == other.Version &&
+		strings.Join(av.Headers, ",") == strings.Join(other.Headers, ",")
+}
+
+// AppVersionHeaders returns the headers a rule finds the app platform and version in
+func (r *Rule) AppVersionHeaders() []string {
+	if r == nil || r.Match == nil {
+		return defaultAppVersionHeaders
+	}
+	return r.Match.AppVersion.headers()
+}
+
+// compileUpgrade validates the upgrade of a rule, if it has one (and checks it does, if it's an upgrade rule)
+func (r *Rule) compileUpgrade() error {
+	if r.Action != ActionUpgrade {
+		if r.Upgrade != nil {
+			return fmt.Errorf("Upgrade given for a %v rule", r.Action)
+		}
+		return nil
+	}
+
+	if r.Upgrade == nil || len(r.Upgrade.StoreUrls) == 0 {
+		return fmt.Errorf("Upgrade rules must have store URLs")
+	}
+	for platform, s := range r.Upgrade.StoreUrls {
+		if platform != PlatformDefault && appPlatforms[platform] != platform {
+			return fmt.Errorf("Invalid store platform %q; must be ios, android, windows or %s", platform, PlatformDefault)
+		}
+		if u, err := url.Parse(s); err != nil || !u.IsAbs() {
+			return fmt.Errorf("Invalid store URL %q for %s; must be absolute", s, platform)
+		}
+	}
+	return nil
+}
+
+// StoreUrl returns where clients on a platform can get a new app, falling back to the default store URL
+func (u *Upgrade) StoreUrl(platform string) string {
+	if s, ok := u.StoreUrls[platform]; ok && platform != "" {
+		return s
+	}
+	return u.StoreUrls[PlatformDefault]
+}
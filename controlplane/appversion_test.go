@@ -0,0 +1,106 @@
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAppVersion(t *testing.T) {
+	cases := []struct {
+		headers  map[string]string
+		platform string
+		version  string
+	}{
+		{map[string]string{"User-Agent": "Hailo/3.1.4 (iOS 9.2; iPhone)"}, "ios", "3.1.4"},
+		{map[string]string{"User-Agent": "HailoDriver/2.7 Android"}, "android", "2.7.0"},
+		{map[string]string{"X-H-App-Version": "3.2.0", "User-Agent": "okhttp/2.5.0 (Android)"}, "android", "3.2.0"},
+		{map[string]string{"X-H-App-Version": "3.2.0", "X-H-Platform": "ipad"}, "ios", "3.2.0"},
+		{map[string]string{"User-Agent": "curl"}, "", ""},
+	}
+	for i, tc := range cases {
+		ext := &testExtractor{headers: tc.headers}
+		av := ParseAppVersion(ext.Header, defaultAppVersionHeaders)
+		assert.Equal(t, tc.platform, av.Platform, "Case %d", i)
+		assert.Equal(t, tc.version != "", av.HasVersion, "Case %d", i)
+		if av.HasVersion {
+			assert.Equal(t, tc.version, av.Version.String(), "Case %d", i)
+		}
+	}
+}
+
+func TestAppVersionValidation(t *testing.T) {
+	valid := []*AppVersionMatch{
+		{Version: "< 3.2.0"},
+		{Version: ">= 3.0, <3.2.0", Platform: "ios,android"},
+		{Platform: "Windows"},
+		{Version: "!= v2", Headers: []string{"X-App"}},
+	}
+	for i, av := range valid {
+		assert.NoError(t, (&Match{AppVersion: av}).compile(), "Case %d", i)
+	}
+
+	invalid := []*AppVersionMatch{
+		{},
+		{Version: "~> 3.2"},
+		{Version: "< 3.2.0,"},
+		{Version: "3.x"},
+		{Platform: "blackberry"},
+		{Version: "3", Headers: []string{""}},
+	}
+	for i, av := range invalid {
+		assert.Error(t, (&Match{AppVersion: av}).compile(), "Case %d", i)
+	}
+}
+
+func TestAppVersionMatching(t *testing.T) {
+	m := &Match{AppVersion: &AppVersionMatch{Platform: "ios", Version: ">= 3.0, < 3.2.0"}, Proportion: 1}
+	assert.NoError(t, m.compile())
+
+	cases := []struct {
+		ua      string
+		matches bool
+	}{
+		{"Hailo/3.1.4 (iOS 9.2)", true},
+		{"Hailo/3.0 (iOS 9.2)", true},
+		{"Hailo/3.2.0 (iOS 9.2)", false},
+		{"Hailo/2.9.9 (iOS 9.2)", false},
+		{"Hailo/3.1.4 (Android 5.1)", false},
+		{"Hailo (iOS)", false}, // we need a version
+		{"", false},
+	}
+	for _, tc := range cases {
+		ext := &testExtractor{headers: map[string]string{"User-Agent": tc.ua}}
+		assert.Equal(t, tc.matches, m.mismatch(ext) == "", "User-Agent %q", tc.ua)
+	}
+
+	// custom headers
+	m = &Match{AppVersion: &AppVersionMatch{Headers: []string{"X-Client"}, Version: "< 3"}, Proportion: 1}
+	assert.NoError(t, m.compile())
+	assert.Equal(t, "", m.mismatch(&testExtractor{headers: map[string]string{"X-Client": "driver-2.1"}}))
+	assert.Equal(t, mismatchAppVersion, m.mismatch(&testExtractor{headers: map[string]string{"User-Agent": "Hailo/2.1"}}))
+}
+
+func TestUpgradeValidation(t *testing.T) {
+	testCases := []struct {
+		rule  *Rule
+		valid bool
+	}{
+		{&Rule{Action: ActionUpgrade, Upgrade: &Upgrade{StoreUrls: map[string]string{"ios": "https://itunes.apple.com/app/id1"}}}, true},
+		{&Rule{Action: ActionUpgrade, Upgrade: &Upgrade{StoreUrls: map[string]string{"default": "https://hailoapp.com"}}}, true},
+		{&Rule{Action: ActionUpgrade}, false},
+		{&Rule{Action: ActionUpgrade, Upgrade: &Upgrade{Message: "Please upgrade"}}, false},
+		{&Rule{Action: ActionUpgrade, Upgrade: &Upgrade{StoreUrls: map[string]string{"ios": "/app"}}}, false},
+		{&Rule{Action: ActionUpgrade, Upgrade: &Upgrade{StoreUrls: map[string]string{"palm": "https://hailoapp.com"}}}, false},
+		{&Rule{Action: ActionThrottle, Upgrade: &Upgrade{StoreUrls: map[string]string{"ios": "https://hailoapp.com"}}}, false},
+	}
+	for i, tc := range testCases {
+		err := tc.rule.Compile()
+		assert.Equal(t, tc.valid, err == nil, "Case %d: %v", i, err)
+	}
+
+	u := &Upgrade{StoreUrls: map[string]string{"ios": "https://itunes.apple.com/app/id1", "default": "https://hailoapp.com"}}
+	assert.Equal(t, "https://itunes.apple.com/app/id1", u.StoreUrl("ios"))
+	assert.Equal(t, "https://hailoapp.com", u.StoreUrl("android"))
+	assert.Equal(t, "https://hailoapp.com", u.StoreUrl(""))
+}
@@ -1,7 +1,8 @@
 package controlplane
 
 // we store a static list of cityMaps since this is only for H1 and we have a fixed
-// set of cities to work with, plus it's simpler
+// set of cities to work with, plus it's simpler. hostHobs in config replace this
+// list, so new hostnames don't need a release
 var hostMap = map[string]string{
 	"api-driver-london.elasticride.com":         "LON",
 	"api-driver-london-test.elasticride.com":    "LON",
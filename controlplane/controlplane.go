@@ -3,10 +3,7 @@ package controlplane
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,37 +13,29 @@ import (
 	"github.com/davegardnerisme/deephash"
 	"gopkg.in/tomb.v2"
 
+	"github.com/HailoOSS/api-proxy/stats"
 	"github.com/HailoOSS/service/config"
 )
 
 const (
-	filename        = "/opt/hailo/var/cache/api-proxy-config"
+	filename        = "/opt/hailo/var/cache/api-proxy-config" // single "last good" config file, from before we kept generations
 	reloadFailDelay = time.Second
 )
 
-// LoadLastGoodConfig loads the last known "good" config from file
-func LoadLastGoodConfig() {
-	// try to load ONCE and never reload
-	f, err := os.Open(filename)
-	if err != nil {
-		log.Errorf("[Control Plane] Last known config load failed: %v", err)
-	}
-	defer f.Close()
-
-	err = config.Load(f)
-	if err != nil {
-		log.Errorf("[Control Plane] Last known config load failed: %v", err)
-	}
-}
-
 // As we want to treat updates to loaded config as an atomic whole, we wrap them in this struct
 type loadedCpConfig struct {
 	rules          SortedRules
 	regions        Regions
 	hobRegions     HobRegions
 	hobModes       HobModes
-	rConfigVersion int64  // region config version - a timestamp
-	configHash     string // hash of ALL config last loaded so we avoid reloading unless changed
+	hobGroups      HobGroups
+	hostHobs       HostHobs
+	hostHobMatcher *hostHobMatcher // compiled hostHobs, or nil to use the built-in hostMap
+	upstreams      Upstreams
+	h2Routes       H2Routes
+	hobLocations   hobLocations // timezones of HOBs, loaded from hobTimezones
+	rConfigVersion int64        // region config version - a timestamp
+	configHash     string       // hash of ALL config last loaded so we avoid reloading unless changed
 }
 
 // ControlPlane represents our config-based system for controlling Hailo traffic
@@ -57,6 +46,14 @@ type ControlPlane struct {
 	tomb.Tomb
 	_loadedConfig unsafe.Pointer // *loadedCpConfig -- dereferenced automatically by loadedConfig()
 	loadCycleLock sync.Mutex     // used to only allow one config reload loop at a time
+	loadLock      sync.Mutex     // used to only load one config at a time, held only while loading (not between retries)
+
+	historyLock sync.RWMutex
+	history     []*ConfigGeneration // the last few generations of config loaded, oldest first
+	generation  int64               // generation of the config last loaded
+
+	pinLock sync.RWMutex
+	pinned  string // ID of the "last good" config generation we're pinned to, if any
 }
 
 // New initialises a control plane that loads via the config service
@@ -96,11 +93,18 @@ func (cp *ControlPlane) loadedConfig() loadedCpConfig {
 // Router takes a request and prepares us for routing it to a backend and/or region
 func (cp *ControlPlane) Router(req *http.Request) Router {
 	return &RuleRouter{
-		extractor: newExtractor(req),
+		extractor: cp.extractorFor(req),
 		control:   cp,
 	}
 }
 
+// extractorFor makes an extractor for a request, which finds HOBs from hostnames with the current host HOBs
+func (cp *ControlPlane) extractorFor(req *http.Request) *extractor {
+	e := newExtractor(req)
+	e.hostHobs = cp.loadedConfig().hostHobMatcher
+	return e
+}
+
 // Regions obtains the current region config from the control plane
 func (cp *ControlPlane) Regions() Regions {
 	if cp == nil {
@@ -162,111 +166,167 @@ type parsedConfig struct {
 }
 
 type parsedControlPlane struct {
-	Rules         Rules      `json:"rules,omitempty"`
-	Regions       Regions    `json:"regions,omitempty"`
-	HobRegions    HobRegions `json:"hobRegions,omitempty"`
-	ConfigVersion float64    `json:"configVersion"`
-	HobModes      HobModes   `json:"hobModes,omitempty"`
+	Rules         Rules        `json:"rules,omitempty"`
+	Regions       Regions      `json:"regions,omitempty"`
+	HobRegions    HobRegions   `json:"hobRegions,omitempty"`
+	ConfigVersion float64      `json:"configVersion"`
+	HobModes      HobModes     `json:"hobModes,omitempty"`
+	HobTimezones  HobTimezones `json:"hobTimezones,omitempty"`
+	HobGroups     HobGroups    `json:"hobGroups,omitempty"`
+	HostHobs      HostHobs     `json:"hostHobs,omitempty"`
+	Upstreams     Upstreams    `json:"upstreams,omitempty"`
+	H2Routes      H2Routes     `json:"h2Routes,omitempty"`
 }
 
 // tryLoad parses config from config service and checks validity, returning an error
 // if any config is invalid -- with fairly strict rules/expectations
 func (cp *ControlPlane) tryLoad() error {
+	cp.loadLock.Lock()
+	defer cp.loadLock.Unlock()
+
+	if pinned := cp.Pinned(); pinned != "" {
+		log.Infof("[Control Plane] Pinned to last good config %s; ignoring config service", pinned)
+		return nil
+	}
+
 	log.Tracef("[Control Plane] Trying to load config")
 	// for our last-known "good copy" -- grab ALL config at once, then chop it up
-	rawConfig := config.Raw()
+	return cp.load(config.Raw(), "")
+}
+
+// load parses raw config and checks validity, returning an error if any config is invalid. pinned is the ID of the
+// "last good" config generation being loaded, if any, in which case we don't save it as a new generation
+func (cp *ControlPlane) load(rawConfig []byte, pinned string) error {
+	parsed, err := parseConfig(rawConfig)
+	if err != nil {
+		return err
+	}
+	cfg, err := compileConfig(parsed)
+	if err != nil {
+		return err
+	}
+
+	// see if anything has changed
+	if cfg.configHash == cp.currentHash() {
+		return nil
+	}
+
+	// update our control plane config now
+	if pinned == "" {
+		if err := cp.saveConfigToFile(rawConfig); err != nil {
+			log.Errorf("[Control Plane] Failed to write last good config: %v", err)
+		}
+	}
+
+	prev := cp.loadedConfig()
+	atomic.StorePointer(&cp._loadedConfig, unsafe.Pointer(cfg))
+	cp.recordGeneration(prev, *cfg, pinned)
+
+	// every change to a rule gives it a new ID, so forget the stats of rules we no longer have
+	ids := []string{DefaultRuleId, ForcedRuleId}
+	for _, r := range cfg.rules {
+		if r != nil {
+			ids = append(ids, r.Id())
+		}
+	}
+	stats.RetainRules(ids)
+
+	log.Infof("[Control Plane] Loaded - %d rules, %d regions, %d HOB regions, %d HOB modes - regionTS=%d",
+		len(cfg.rules), len(cfg.regions), len(cfg.hobRegions), len(cfg.hobModes), cfg.rConfigVersion)
+
+	return nil
+}
 
+// parseConfig parses raw config, as it comes from the config service
+func parseConfig(rawConfig []byte) (*parsedConfig, error) {
 	if len(rawConfig) == 0 {
-		return fmt.Errorf("error loading config -- zero length")
+		return nil, fmt.Errorf("error loading config -- zero length")
 	}
 
-	parsed := parsedConfig{}
-	if err := json.Unmarshal(rawConfig, &parsed); err != nil {
-		return fmt.Errorf("JSON unmarshal error: %v", err)
+	parsed := &parsedConfig{}
+	if err := json.Unmarshal(rawConfig, parsed); err != nil {
+		return nil, fmt.Errorf("JSON unmarshal error: %v", err)
 	}
+	return parsed, nil
+}
 
+// compileConfig checks parsed config is valid and compiles it (in place) ready to route with. This decides whether
+// config loads, both for real and when linting
+func compileConfig(parsed *parsedConfig) (*loadedCpConfig, error) {
 	sorted := parsed.Cp.Rules.Sort()
 	regions, hobRegions, hobModes := parsed.Cp.Regions, parsed.Cp.HobRegions, parsed.Cp.HobModes
+	hobTimezones, hobGroups, upstreams := parsed.Cp.HobTimezones, parsed.Cp.HobGroups, parsed.Cp.Upstreams
+	hostHobs, h2Routes := parsed.Cp.HostHobs, parsed.Cp.H2Routes
 	configVersion := int64(parsed.Cp.ConfigVersion)
 
 	// sanity check
 	if err := sorted.Validate(); err != nil {
-		return err
+		return nil, err
 	}
 	if err := regions.Validate(); err != nil {
-		return err
+		return nil, err
+	}
+	if err := hobGroups.Validate(); err != nil {
+		return nil, err
+	}
+	locations, err := hobTimezones.Locations()
+	if err != nil {
+		return nil, err
 	}
 
-	// see if anything has changed
+	// hash before compiling, so we can tell if anything has changed
 	h := deephash.Hash([]interface{}{
 		sorted,
 		regions,
 		hobRegions,
 		configVersion,
 		hobModes,
+		hobTimezones,
+		hobGroups,
+		hostHobs,
+		upstreams,
+		h2Routes,
 	})
 
-	newHash := fmt.Sprintf("%x", h)
-	currHash := cp.currentHash()
-
-	if newHash == currHash {
-		return nil
+	// compile matchers and schedules (regexes, path templates, windows) up front so we don't pay for them on every
+	// request
+	if err := sorted.Compile(); err != nil {
+		return nil, err
+	}
+	if err := sorted.ExpandHobGroups(hobGroups); err != nil {
+		return nil, err
+	}
+	if err := upstreams.Compile(); err != nil {
+		return nil, err
+	}
+	hostHobMatcher, err := hostHobs.Compile()
+	if err != nil {
+		return nil, err
+	}
+	if err := sorted.ValidateUpstreams(upstreams); err != nil {
+		return nil, err
+	}
+	if err := h2Routes.Compile(); err != nil {
+		return nil, err
 	}
 
-	// update our control plane config now
-	tmp := &ControlPlane{}
-	atomic.StorePointer(&tmp._loadedConfig, unsafe.Pointer(&loadedCpConfig{
-
+	return &loadedCpConfig{
 		rules:          sorted,
 		regions:        regions,
 		hobRegions:     hobRegions,
 		rConfigVersion: configVersion,
 		hobModes:       hobModes,
-		configHash:     newHash,
-	}))
-	err := tmp.saveConfigToFile(rawConfig)
-	if err != nil {
-		log.Errorf("[Control Plane] Failed to write last good config: %v", err)
-	}
-
-	cfg := tmp.loadedConfig()
-	atomic.StorePointer(&cp._loadedConfig, unsafe.Pointer(&cfg))
-
-	log.Infof("[Control Plane] Loaded - %d rules, %d regions, %d HOB regions, %d HOB modes - regionTS=%d", len(sorted),
-		len(regions), len(hobRegions), len(hobModes), configVersion)
-
-	return nil
+		hobGroups:      hobGroups,
+		hostHobs:       hostHobs,
+		hostHobMatcher: hostHobMatcher,
+		hobLocations:   locations,
+		upstreams:      upstreams,
+		h2Routes:       h2Routes,
+		configHash:     fmt.Sprintf("%x", h),
+	}, nil
 }
 
 // currentHash just returns the current config hash
 func (cp *ControlPlane) currentHash() string {
 	return cp.loadedConfig().configHash
 }
-
-// saveConfigToFile will save the current config to file -- this is called after every
-// successful load/validate cycle, so we know at this point the config is valid
-func (cp *ControlPlane) saveConfigToFile(c []byte) error {
-	dirPath := filepath.Dir(filename)
-
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return err
-	}
-
-	// Minimise the likelihood of borking the existing config by doing a write to a temp file and then a move
-	tmpFile, err := ioutil.TempFile(dirPath, filepath.Base(filename))
-	if err != nil {
-		return err
-	}
-
-	if err := ioutil.WriteFile(tmpFile.Name(), c, 0644); err != nil {
-		return fmt.Errorf("Error writing config file: %v", err)
-	}
-
-	// Shuffle the temp file to the new file
-	if err := os.Rename(tmpFile.Name(), filename); err != nil {
-		return err
-	}
-
-	log.Infof("[Control Plane] Last good config saved to '%v'", filename)
-	return nil
-}
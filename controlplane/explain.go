@@ -0,0 +1,119 @@
+package controlplane
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultRuleId is reported as the rule ID when no rule matched, and so the request falls through to H2
+	DefaultRuleId = "default"
+	// ForcedRuleId is reported as the rule ID when the route was forced via X-Hailo-Route
+	ForcedRuleId = "forced"
+)
+
+// An Explanation describes how the control plane evaluated a request: every rule it considered, which one it chose
+// and where it would send the request
+type Explanation struct {
+	Method        string             `json:"method"`
+	Host          string             `json:"host"`
+	Path          string             `json:"path"`
+	Hob           string             `json:"hob"`
+	HobSource     string             `json:"hobSource,omitempty"` // where the HOB came from: a param, or the host pattern that matched
+	Source        string             `json:"source"`
+	Forced        string             `json:"forced,omitempty"` // value of X-Hailo-Route, if given
+	Rules         []*RuleExplanation `json:"rules"`
+	RuleId        string             `json:"ruleId"`
+	Action        string             `json:"action"`
+	RewrittenPath string             `json:"rewrittenPath,omitempty"` // path we'd dispatch, if the rule rewrites it
+	HobMode       string             `json:"hobMode"`
+	Region        string             `json:"region"`
+	FailoverPath  []string           `json:"failoverPath"` // regions considered, in order, ending with the chosen one
+	ConfigVersion int64              `json:"configVersion"`
+}
+
+// A RuleExplanation describes how a single rule was evaluated against a request
+type RuleExplanation struct {
+	Id          string `json:"id"`
+	Rule        *Rule  `json:"rule"`
+	Specificity int    `json:"specificity"`
+	Matched     bool   `json:"matched"`
+	Reason      string `json:"reason,omitempty"` // why the rule didn't match, eg: "path" or "header X-H-Foo"
+	Shadow      bool   `json:"shadow,omitempty"`
+	Chosen      bool   `json:"chosen"`
+}
+
+// Explain evaluates a request against the current config without routing it, describing how it would be handled
+func (cp *ControlPlane) Explain(req *http.Request) *Explanation {
+	return (&RuleRouter{
+		extractor: cp.extractorFor(req),
+		control:   cp,
+	}).Explain()
+}
+
+// Explain describes how this router would route its request. Unlike Route, every rule is evaluated (even after a
+// match is found) and shadow rules aren't recorded
+func (r *RuleRouter) Explain() *Explanation {
+	loadedCfg := r.control.loadedConfig()
+	now := time.Now()
+
+	e := &Explanation{
+		Host:          r.extractor.Host(),
+		Path:          r.extractor.Path(),
+		Method:        r.extractor.Method(),
+		Hob:           r.extractor.Hob(),
+		Source:        r.extractor.Source(),
+		Forced:        r.extractor.Header("X-Hailo-Route"),
+		Rules:         make([]*RuleExplanation, 0, len(loadedCfg.rules)),
+		RuleId:        DefaultRuleId,
+		Action:        ActionSendToH2.String(),
+		HobMode:       r.GetHobMode(),
+		FailoverPath:  []string{},
+		ConfigVersion: loadedCfg.rConfigVersion,
+	}
+	if ext, ok := r.extractor.(*extractor); ok {
+		e.HobSource = ext.hobSource
+	}
+
+	var chosen *RuleExplanation
+	for _, rule := range loadedCfg.rules {
+		reason := r.evaluate(loadedCfg, rule, now)
+		re := &RuleExplanation{
+			Id:          rule.Id(),
+			Rule:        rule,
+			Specificity: rule.Specificity(),
+			Matched:     reason == "",
+			Reason:      reason,
+			Shadow:      rule.Shadow,
+		}
+		if re.Matched && !re.Shadow && chosen == nil {
+			chosen = re
+			re.Chosen = true
+			e.RuleId = re.Id
+			// for split rules, this is the way this request would go
+			e.Action = rule.resolveSplit(r.extractor).Action.String()
+			if rule.Rewrite != nil {
+				e.RewrittenPath = rule.Rewrite.RewritePath(e.Path)
+			}
+		}
+		e.Rules = append(e.Rules, re)
+	}
+
+	// a forced route trumps any rule (although we still explain how the rules would have matched)
+	if e.Forced != "" {
+		if rule := r.forceRoute(e.Forced); rule != nil {
+			if chosen != nil {
+				chosen.Chosen = false
+			}
+			e.RuleId = RuleId(rule)
+			e.Action = rule.Action.String()
+			e.RewrittenPath = ""
+		}
+	}
+
+	if region := r.region(loadedCfg, &e.FailoverPath); region != nil {
+		e.Region = region.Id
+	}
+
+	return e
+}
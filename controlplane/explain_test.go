@@ -0,0 +1,122 @@
+package controlplane
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/service/config"
+)
+
+func TestExplain(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	loadRules()
+	cp := New()
+
+	r := &RuleRouter{
+		extractor: &testExtractor{
+			hob:    "LON",
+			source: "driver",
+			path:   "/v1/driver/index",
+			method: "GET",
+		},
+		control: cp,
+	}
+
+	e := r.Explain()
+	assert.Equal(t, "LON", e.Hob)
+	assert.Equal(t, "driver", e.Source)
+	assert.Equal(t, "GET", e.Method)
+	assert.Equal(t, "h1", e.HobMode)
+	assert.Equal(t, "eu-west-1", e.Region)
+	assert.Equal(t, []string{"eu-west-1"}, e.FailoverPath)
+	assert.Equal(t, ActionProxyToH1.String(), e.Action)
+	assert.Equal(t, RuleId(r.Route()), e.RuleId)
+
+	// every rule is explained, in order, with exactly one chosen
+	rules := cp.Rules()
+	assert.Len(t, e.Rules, len(rules))
+	chosen := 0
+	for i, re := range e.Rules {
+		assert.Equal(t, rules[i].Id(), re.Id)
+		assert.Equal(t, rules[i].Specificity(), re.Specificity)
+		assert.Equal(t, re.Matched, re.Reason == "", "Rule %s: matched=%v reason=%q", re.Id, re.Matched, re.Reason)
+		if re.Chosen {
+			chosen++
+			assert.Equal(t, e.RuleId, re.Id)
+		}
+		if rules[i].Action == ActionThrottle {
+			assert.Equal(t, mismatchPath, re.Reason)
+		}
+	}
+	assert.Equal(t, 1, chosen)
+}
+
+func TestExplainDefault(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	loadRules()
+	cp := New()
+
+	r := &RuleRouter{
+		extractor: &testExtractor{
+			hob:  "ATL",
+			path: "/v1/driver/index",
+		},
+		control: cp,
+	}
+
+	e := r.Explain()
+	assert.Equal(t, DefaultRuleId, e.RuleId)
+	assert.Equal(t, ActionSendToH2.String(), e.Action)
+	for _, re := range e.Rules {
+		assert.False(t, re.Matched, "Rule %s should not match", re.Id)
+		assert.False(t, re.Chosen)
+	}
+}
+
+func TestExplainForced(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	loadRules()
+	cp := New()
+
+	r := &RuleRouter{
+		extractor: &testExtractor{
+			hob:     "LON",
+			path:    "/v2/throttle",
+			headers: map[string]string{"X-Hailo-Route": "H2"},
+		},
+		control: cp,
+	}
+
+	e := r.Explain()
+	assert.Equal(t, "H2", e.Forced)
+	assert.Equal(t, ForcedRuleId, e.RuleId)
+	assert.Equal(t, ActionSendToH2.String(), e.Action)
+	for _, re := range e.Rules {
+		assert.False(t, re.Chosen)
+	}
+}
+
+func TestExplainFailoverPath(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	loadRules()
+	cp := New()
+
+	cp.loadedConfig().regions["eu-west-1"].Status = "OFFLINE"
+
+	r := &RuleRouter{
+		extractor: &testExtractor{
+			hob: "LON",
+		},
+		control: cp,
+	}
+
+	e := r.Explain()
+	assert.Equal(t, "us-east-1", e.Region)
+	assert.Equal(t, []string{"eu-west-1", "us-east-1"}, e.FailoverPath)
+}
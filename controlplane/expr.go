@@ -0,0 +1,469 @@
+package controlplane
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Expressions let a match combine conditions with AND, OR and NOT, which the other criteria can't. They're a small
+// language with no loops or side effects, that can only read the request, like:
+//
+//	(hob == "LON" || hob in ["DUB", "ORK"]) && !startsWith(path, "/v1/internal") && header("X-H-Beta") != ""
+//
+// Variables are hob, source, path, host and method; header(name), value(name) and cookie(name) read the request;
+// startsWith, endsWith and contains test strings, and lower lower-cases them. == and != compare strings, =~ and !~
+// test them against a regex literal, and in tests them against a list of string literals
+
+const (
+	// maxExprLength and maxExprDepth keep expressions small enough to evaluate quickly (and parse without blowing the
+	// stack)
+	maxExprLength = 4096
+	maxExprDepth  = 32
+)
+
+type exprKind int
+
+const (
+	exprBool exprKind = iota
+	exprString
+	exprList
+)
+
+func (k exprKind) String() string {
+	switch k {
+	case exprBool:
+		return "boolean"
+	case exprString:
+		return "string"
+	default:
+		return "list"
+	}
+}
+
+// exprNode is a compiled (sub-)expression. Only the evaluator for its kind is set, and lists are always literals
+type exprNode struct {
+	kind    exprKind
+	boolean func(ext Extractor) bool
+	str     func(ext Extractor) string
+	list    []string
+	literal *string // literal is set for string literals, so we can compile regexes up front
+}
+
+// exprVars are the request fields expressions can refer to by name
+var exprVars = map[string]func(ext Extractor) string{
+	"hob":    func(ext Extractor) string { return ext.Hob() },
+	"source": func(ext Extractor) string { return ext.Source() },
+	"path":   func(ext Extractor) string { return ext.Path() },
+	"host":   func(ext Extractor) string { return ext.Host() },
+	"method": func(ext Extractor) string { return ext.Method() },
+}
+
+// exprRequestFuncs read a named field of the request
+var exprRequestFuncs = map[string]func(ext Extractor, name string) string{
+	"header": func(ext Extractor, name string) string { return ext.Header(name) },
+	"value":  func(ext Extractor, name string) string { return ext.Value(name) },
+	"cookie": func(ext Extractor, name string) string { return ext.Cookie(name) },
+}
+
+// exprStringFuncs test a string against another
+var exprStringFuncs = map[string]func(s, t string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+}
+
+// compileExpr parses and type checks an expression, returning a function that evaluates it against a request
+func compileExpr(s string) (func(ext Extractor) bool, error) {
+	if len(s) > maxExprLength {
+		return nil, fmt.Errorf("Expression is longer than %d characters", maxExprLength)
+	}
+	tokens, err := lexExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("Unexpected %s at %d", t, t.pos)
+	}
+	if n.kind != exprBool {
+		return nil, fmt.Errorf("Expression must be a boolean, not a %v", n.kind)
+	}
+	return n.boolean, nil
+}
+
+// compileExpr parses the expression of a match, if any, storing it for use when matching
+func (m *Match) compileExpr() error {
+	m.expr = nil
+	if len(m.Expr) == 0 {
+		return nil
+	}
+	f, err := compileExpr(m.Expr)
+	if err != nil {
+		return fmt.Errorf("Invalid expr: %v", err)
+	}
+	m.expr = f
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEnd:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// exprOps are the operators and punctuation of expressions, longest first
+var exprOps = []string{"&&", "||", "==", "!=", "=~", "!~", "!", "(", ")", "[", "]", ","}
+
+func lexExpr(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			str, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, i)
+			}
+			tokens = append(tokens, token{tokenString, str, i})
+			i += n
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, s[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range exprOps {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("Unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{tokenOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokenEnd, "", len(s)}), nil
+}
+
+// lexString reads a quoted string literal, where \ escapes the next character, returning it and how long it was
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b []byte
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return string(b), i + 1, nil
+		case '\\':
+			if i++; i == len(s) {
+				break
+			}
+			fallthrough
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("Unterminated string")
+}
+
+// exprParser is a recursive descent parser, which compiles expressions as it goes
+type exprParser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOp && t.text == op
+}
+
+func (p *exprParser) expectOp(op string) error {
+	if t := p.next(); t.kind != tokenOp || t.text != op {
+		return fmt.Errorf("Expected %q at %d, got %s", op, t.pos, t)
+	}
+	return nil
+}
+
+func expectKind(n *exprNode, kind exprKind, what string) error {
+	if n.kind != kind {
+		return fmt.Errorf("%s must be a %v, not a %v", what, kind, n.kind)
+	}
+	return nil
+}
+
+// parseOr parses a || b || ...
+func (p *exprParser) parseOr() (*exprNode, error) {
+	if p.depth++; p.depth > maxExprDepth {
+		return nil, fmt.Errorf("Expression is nested more than %d deep", maxExprDepth)
+	}
+	defer func() { p.depth-- }()
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := expectKind(left, exprBool, "Left of ||"); err != nil {
+			return nil, err
+		}
+		if err := expectKind(right, exprBool, "Right of ||"); err != nil {
+			return nil, err
+		}
+		l, r := left.boolean, right.boolean
+		left = &exprNode{kind: exprBool, boolean: func(ext Extractor) bool { return l(ext) || r(ext) }}
+	}
+	return left, nil
+}
+
+// parseAnd parses a && b && ...
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := expectKind(left, exprBool, "Left of &&"); err != nil {
+			return nil, err
+		}
+		if err := expectKind(right, exprBool, "Right of &&"); err != nil {
+			return nil, err
+		}
+		l, r := left.boolean, right.boolean
+		left = &exprNode{kind: exprBool, boolean: func(ext Extractor) bool { return l(ext) && r(ext) }}
+	}
+	return left, nil
+}
+
+// parseNot parses !a, or a comparison
+func (p *exprParser) parseNot() (*exprNode, error) {
+	if !p.isOp("!") {
+		return p.parseComparison()
+	}
+	p.next()
+	if p.depth++; p.depth > maxExprDepth {
+		return nil, fmt.Errorf("Expression is nested more than %d deep", maxExprDepth)
+	}
+	defer func() { p.depth-- }()
+
+	n, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if err := expectKind(n, exprBool, "Operand of !"); err != nil {
+		return nil, err
+	}
+	f := n.boolean
+	return &exprNode{kind: exprBool, boolean: func(ext Extractor) bool { return !f(ext) }}, nil
+}
+
+// parseComparison parses a == b, a != b, a =~ "regex", a !~ "regex" or a in [...], or a lone operand
+func (p *exprParser) parseComparison() (*exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	op := t.text
+	switch {
+	case t.kind == tokenOp && (op == "==" || op == "!=" || op == "=~" || op == "!~"):
+	case t.kind == tokenIdent && op == "in":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if err := expectKind(left, exprString, "Left of "+op); err != nil {
+		return nil, err
+	}
+	l := left.str
+
+	switch op {
+	case "in":
+		if err := expectKind(right, exprList, "Right of in"); err != nil {
+			return nil, err
+		}
+		values := make(map[string]bool, len(right.list))
+		for _, v := range right.list {
+			values[v] = true
+		}
+		return &exprNode{kind: exprBool, boolean: func(ext Extractor) bool { return values[l(ext)] }}, nil
+	case "=~", "!~":
+		if right.literal == nil {
+			return nil, fmt.Errorf("Right of %s must be a regex string literal", op)
+		}
+		re, err := regexp.Compile(*right.literal)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regex %q: %v", *right.literal, err)
+		}
+		want := op == "=~"
+		return &exprNode{kind: exprBool, boolean: func(ext Extractor) bool { return re.MatchString(l(ext)) == want }}, nil
+	default:
+		if err := expectKind(right, exprString, "Right of "+op); err != nil {
+			return nil, err
+		}
+		r, want := right.str, op == "=="
+		return &exprNode{kind: exprBool, boolean: func(ext Extractor) bool { return (l(ext) == r(ext)) == want }}, nil
+	}
+}
+
+// parseOperand parses a bracketed expression, string literal, list literal, boolean, variable or function call
+func (p *exprParser) parseOperand() (*exprNode, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenOp && t.text == "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expectOp(")")
+
+	case t.kind == tokenOp && t.text == "[":
+		var list []string
+		for !p.isOp("]") {
+			if len(list) > 0 {
+				if err := p.expectOp(","); err != nil {
+					return nil, err
+				}
+			}
+			v := p.next()
+			if v.kind != tokenString {
+				return nil, fmt.Errorf("Lists may only contain strings, not %s at %d", v, v.pos)
+			}
+			list = append(list, v.text)
+		}
+		p.next()
+		return &exprNode{kind: exprList, list: list}, nil
+
+	case t.kind == tokenString:
+		s := t.text
+		return &exprNode{kind: exprString, str: func(Extractor) string { return s }, literal: &s}, nil
+
+	case t.kind == tokenIdent && (t.text == "true" || t.text == "false"):
+		b := t.text == "true"
+		return &exprNode{kind: exprBool, boolean: func(Extractor) bool { return b }}, nil
+
+	case t.kind == tokenIdent && p.isOp("("):
+		return p.parseCall(t)
+
+	case t.kind == tokenIdent:
+		if f, ok := exprVars[t.text]; ok {
+			return &exprNode{kind: exprString, str: f}, nil
+		}
+		return nil, fmt.Errorf("Unknown variable %s at %d; must be one of hob, source, path, host or method", t.text, t.pos)
+	}
+	return nil, fmt.Errorf("Unexpected %s at %d", t, t.pos)
+}
+
+// parseCall parses the arguments of a function call, checking they suit the function
+func (p *exprParser) parseCall(name token) (*exprNode, error) {
+	p.next() // (
+	var args []*exprNode
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			if err := p.expectOp(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := expectKind(arg, exprString, fmt.Sprintf("Argument %d of %s", len(args)+1, name.text)); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	wantArgs := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s takes %d argument(s), not %d", name.text, n, len(args))
+		}
+		return nil
+	}
+	if f, ok := exprRequestFuncs[name.text]; ok {
+		if err := wantArgs(1); err != nil {
+			return nil, err
+		}
+		if args[0].literal == nil {
+			return nil, fmt.Errorf("The argument of %s must be a string literal", name.text)
+		}
+		field := *args[0].literal
+		return &exprNode{kind: exprString, str: func(ext Extractor) string { return f(ext, field) }}, nil
+	}
+	if f, ok := exprStringFuncs[name.text]; ok {
+		if err := wantArgs(2); err != nil {
+			return nil, err
+		}
+		s, t := args[0].str, args[1].str
+		return &exprNode{kind: exprBool, boolean: func(ext Extractor) bool { return f(s(ext), t(ext)) }}, nil
+	}
+	if name.text == "lower" {
+		if err := wantArgs(1); err != nil {
+			return nil, err
+		}
+		s := args[0].str
+		return &exprNode{kind: exprString, str: func(ext Extractor) string { return strings.ToLower(s(ext)) }}, nil
+	}
+	return nil, fmt.Errorf("Unknown function %s at %d", name.text, name.pos)
+}
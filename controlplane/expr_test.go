@@ -0,0 +1,75 @@
+package controlplane
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExprValidation(t *testing.T) {
+	valid := []string{
+		`hob == "LON"`,
+		`hob == 'LON' || (source == "driver" && !(path =~ "^/v1/internal"))`,
+		`hob in ["LON", "DUB"] && method != "GET"`,
+		`startsWith(lower(header("User-Agent")), "hailo") || value("customer") == ""`,
+		`contains(host, "customer") && cookie('beta') != "" && true`,
+		`path !~ "/v[0-9]+/order" && endsWith(path, "/cancel")`,
+		`header("X-Quote\"d") == "a\\b"`,
+	}
+	for i, expr := range valid {
+		assert.NoError(t, (&Match{Expr: expr}).compile(), "Case %d", i)
+	}
+
+	invalid := []string{
+		`hob`,                       // not a boolean
+		`hob == `,                   // incomplete
+		`hob = "LON"`,               // unknown operator
+		`hob == "LON`,               // unterminated
+		`city == "LON"`,             // unknown variable
+		`exec("rm") == ""`,          // unknown function
+		`hob == "LON" && "DUB"`,     // && a string
+		`!hob`,                      // ! a string
+		`hob == true`,               // comparing a boolean
+		`hob in "LON"`,              // in a string
+		`hob in ["LON", hob]`,       // lists of literals only
+		`path =~ "("`,               // bad regex
+		`path =~ hob`,               // regexes must be literals
+		`header(hob) == ""`,         // header names must be literals
+		`startsWith(path)`,          // wrong number of args
+		`(hob == "LON"`,             // unbalanced
+		`hob == "LON")`,             // unbalanced
+		`hob == "LON" hob == "DUB"`, // missing operator
+		strings.Repeat("(", 40) + "true" + strings.Repeat(")", 40),
+		`hob == "` + strings.Repeat("x", maxExprLength) + `"`,
+	}
+	for i, expr := range invalid {
+		assert.Error(t, (&Match{Expr: expr}).compile(), "Case %d", i)
+	}
+}
+
+func TestExprMatching(t *testing.T) {
+	expr := `(hob == "LON" || hob in ["DUB", "ORK"]) && !startsWith(path, "/v1/internal") && ` +
+		`(header("X-H-Beta") != "" || value("customer") =~ "^1[0-9]+$")`
+	m := &Match{Expr: expr, Proportion: 1}
+	assert.NoError(t, m.compile())
+
+	cases := []struct {
+		ext     *testExtractor
+		matches bool
+	}{
+		{&testExtractor{hob: "LON", path: "/v1/order", headers: map[string]string{"X-H-Beta": "1"}}, true},
+		{&testExtractor{hob: "DUB", path: "/v1/order", values: map[string]string{"customer": "123"}}, true},
+		{&testExtractor{hob: "DUB", path: "/v1/order", values: map[string]string{"customer": "223"}}, false},
+		{&testExtractor{hob: "NYC", path: "/v1/order", headers: map[string]string{"X-H-Beta": "1"}}, false},
+		{&testExtractor{hob: "LON", path: "/v1/internal/x", headers: map[string]string{"X-H-Beta": "1"}}, false},
+		{&testExtractor{hob: "LON", path: "/v1/order"}, false},
+	}
+	for i, tc := range cases {
+		assert.Equal(t, tc.matches, m.mismatch(tc.ext) == "", "Case %d", i)
+	}
+	assert.Equal(t, mismatchExpr, m.mismatch(&testExtractor{hob: "NYC"}))
+
+	// an uncompiled expression never matches
+	assert.Equal(t, mismatchExpr, (&Match{Expr: `true`, Proportion: 1}).mismatch(&testExtractor{}))
+}
@@ -5,11 +5,13 @@ import (
 	"io"
 	"io/ioutil"
 	"mime"
+	"net"
 	"net/http"
 	"strings"
 
 	log "github.com/cihub/seelog"
 
+	"github.com/HailoOSS/api-proxy/jsonbody"
 	"github.com/HailoOSS/api-proxy/session"
 )
 
@@ -28,6 +30,10 @@ type extractor struct {
 	// Contains extracted values from HTTP body or query (in that order – ie. a parameter found in BOTH the body and the
 	// query will take the value from the body)
 	extractedValues map[string]string
+	// hostHobs finds HOBs from hostnames; nil to use the built-in hostMap
+	hostHobs *hostHobMatcher
+	// hobSource is where Hob() first found the HOB: "param", or "host " and the host pattern that matched
+	hobSource string
 }
 
 // Creates a new extractor (which will load values from the request lazily)
@@ -54,8 +60,8 @@ func (e *extractor) CityOrHob() string {
 	return e.Value(hobCodeKey)
 }
 
-// Hob will extract a city code from the request, looking at either a hostname match against a known list, or a
-// query/body parameter match
+// Hob will extract a city code from the request, looking at either a query/body parameter match, or a hostname match
+// against the host HOBs from config (or, without any, a known list)
 func (e *extractor) Hob() string {
 	if e.req == nil {
 		log.Trace("[extractor] req is nil, cannot determine Hob()")
@@ -64,12 +70,16 @@ func (e *extractor) Hob() string {
 
 	hob := e.CityOrHob()
 	if len(hob) != 0 {
+		if e.hobSource == "" {
+			e.hobSource = "param"
+		}
 		return hob
 	}
 
-	if code, ok := hostMap[e.req.Host]; ok {
+	if code, pattern := hobForHost(e.hostHobs, e.req.Host); len(code) > 0 {
 		// Add to the query string
-		log.Tracef("[extractor] HOB match to %s from HTTP Host header: %s", code, e.req.Host)
+		log.Tracef("[extractor] HOB match to %s from HTTP Host header: %s (%s)", code, e.req.Host, pattern)
+		e.hobSource = "host " + pattern
 		e.SetHob(code)
 
 		return code
@@ -119,6 +129,29 @@ func (e *extractor) Header(hdr string) string {
 	return e.req.Header.Get(hdr)
 }
 
+// RemoteIP gets the IP address of the client, from the request's RemoteAddr
+func (e *extractor) RemoteIP() net.IP {
+	host, _, err := net.SplitHostPort(e.req.RemoteAddr)
+	if err != nil {
+		host = e.req.RemoteAddr // no port
+	}
+	return net.ParseIP(host)
+}
+
+// Cookie gets the value of the given HTTP cookie from the request
+func (e *extractor) Cookie(name string) string {
+	c, err := e.req.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// Method returns the HTTP method of the request, eg: GET
+func (e *extractor) Method() string {
+	return e.req.Method
+}
+
 // doExtraction is invoked lazily when required to parse the HTTP request
 func (e *extractor) doExtraction() {
 	e.extractedValues = make(map[string]string)
@@ -129,6 +162,16 @@ func (e *extractor) doExtraction() {
 		e.extractedValues[k] = vs[0]
 	}
 
+	// JSON bodies don't have a form, so we read their fields instead. We needn't copy the body for that, and mustn't,
+	// since the fields are kept with it for everything else that wants them
+	if e.req.Body != nil && jsonbody.IsJson(e.req) {
+		for k, vs := range jsonbody.Values(e.req) {
+			e.extractedValues[k] = vs[0]
+		}
+		e.extractedValues["session_id"] = session.SessionId(e.req)
+		return
+	}
+
 	// Copy the request body, and restore it on exit
 	savedRequestBody := e.req.Body
 	defer func() {
@@ -2,13 +2,18 @@ package controlplane
 
 import (
 	"bytes"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"testing"
+
+	"github.com/HailoOSS/api-proxy/jsonbody"
 )
 
 type testExtractor struct {
-	hob, path, source, host string
-	values, headers         map[string]string
+	hob, path, source, host, method string
+	values, headers, cookies        map[string]string
+	remoteIP                        net.IP
 }
 
 func (e *testExtractor) Hob() string       { return e.hob }
@@ -16,6 +21,7 @@ func (e *testExtractor) Path() string      { return e.path }
 func (e *testExtractor) SetHob(hob string) { e.hob = hob }
 func (e *testExtractor) Source() string    { return e.source }
 func (e *testExtractor) Host() string      { return e.host }
+func (e *testExtractor) Method() string    { return e.method }
 func (e *testExtractor) Value(name string) string {
 	if e.values == nil {
 		return ""
@@ -28,6 +34,13 @@ func (e *testExtractor) Header(name string) string {
 	}
 	return e.headers[name]
 }
+func (e *testExtractor) RemoteIP() net.IP { return e.remoteIP }
+func (e *testExtractor) Cookie(name string) string {
+	if e.cookies == nil {
+		return ""
+	}
+	return e.cookies[name]
+}
 
 // TestHobExtraction sets hob/city param in query string and body then attempts
 // extraction.
@@ -67,6 +80,33 @@ func TestHobExtraction(t *testing.T) {
 	}
 }
 
+// TestJsonExtraction reads values from a JSON body, which must be left intact
+func TestJsonExtraction(t *testing.T) {
+	body := `{"city":"LON","customer":123,"foo":{"bar":"baz"}}`
+	r, _ := http.NewRequest("POST", "/v1/point/batch?customer=456", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/json")
+	e := newExtractor(r)
+
+	if hob := e.Hob(); hob != "LON" {
+		t.Errorf("Expected hob LON, got %s", hob)
+	}
+	if customer := e.Value("customer"); customer != "123" {
+		t.Errorf("Expected customer 123 (from the body), got %s", customer)
+	}
+	if foo := e.Value("foo"); foo != "" {
+		t.Errorf("Expected no foo (as it's an object), got %s", foo)
+	}
+
+	b, _ := ioutil.ReadAll(r.Body)
+	if string(b) != body {
+		t.Errorf("Expected the body to be intact, got %s", string(b))
+	}
+	// and what we decoded is kept with it, for everything else that wants it
+	if city := jsonbody.Values(r).Get("city"); city != "LON" {
+		t.Errorf("Expected the city decoded during extraction, got %q", city)
+	}
+}
+
 func TestSourceExtraction(t *testing.T) {
 	// Set Source to `customer` in hostname
 	r, _ := http.NewRequest("GET", "http://api-customer.elasticride.com", nil)
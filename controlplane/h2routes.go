@@ -0,0 +1,81 @@
+package controlplane
+
+import (
+	"fmt"
+)
+
+// Compile validates the H2 routes, parsing their path templates
+func (hr H2Routes) Compile() error {
+	for i, r := range hr {
+		if r == nil {
+			return fmt.Errorf("H2 route %d is missing", i)
+		}
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("H2 route %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *H2Route) compile() error {
+	r.pathTemplate = nil
+	if len(r.PathTemplate) == 0 {
+		return fmt.Errorf("Must have a path template")
+	}
+	if len(r.Service) == 0 || len(r.Endpoint) == 0 {
+		return fmt.Errorf("Must have a service and an endpoint")
+	}
+	tpl, err := parsePathTemplate(r.PathTemplate)
+	if err != nil {
+		return err
+	}
+	r.pathTemplate = tpl
+	return nil
+}
+
+// find returns the first (compiled) route whose template matches a path, along with its placeholder values
+func (hr H2Routes) find(p string) (*H2Route, map[string]string) {
+	for _, r := range hr {
+		if r == nil || r.pathTemplate == nil {
+			continue
+		}
+		if params, ok := r.pathTemplate.match(p); ok {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+// endpoints returns the service and endpoint of every H2 route, indexed by path template
+func (hr H2Routes) endpoints() map[string]string {
+	result := make(map[string]string, len(hr))
+	for _, r := range hr {
+		if r != nil {
+			result[r.PathTemplate] = r.Service + "." + r.Endpoint
+		}
+	}
+	return result
+}
+
+// covers tells us if this template matches every path the other does
+func (t pathTemplate) covers(other pathTemplate) bool {
+	if len(t) != len(other) {
+		return false
+	}
+	for i, seg := range t {
+		if !seg.wild && (other[i].wild || other[i].literal != seg.literal) {
+			return false
+		}
+	}
+	return true
+}
+
+// H2Endpoint finds the H2 service and endpoint for a path from the H2 routes in config, along with any params taken
+// from the path. ok is false if no route matches, in which case the usual convention applies
+func (cp *ControlPlane) H2Endpoint(p string) (service, endpoint string, params map[string]string, ok bool) {
+	r, params := cp.loadedConfig().h2Routes.find(p)
+	if r == nil {
+		return "", "", nil, false
+	}
+	return r.Service, r.Endpoint, params, true
+}
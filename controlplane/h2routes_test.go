@@ -0,0 +1,133 @@
+package controlplane
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestH2RoutesCompile(t *testing.T) {
+	valid := []H2Routes{
+		nil,
+		{{PathTemplate: "/v1/order/{id}/cancel", Service: "com.HailoOSS.api.order", Endpoint: "cancel"}},
+		{{PathTemplate: "/v2/*/ping", Service: "com.HailoOSS.api.system", Endpoint: "ping"}},
+	}
+	for i, hr := range valid {
+		assert.NoError(t, hr.Compile(), "Case %d", i)
+	}
+
+	invalid := []H2Routes{
+		{nil},
+		{{Service: "com.HailoOSS.api.order", Endpoint: "cancel"}},
+		{{PathTemplate: "/v1/order/{id}/cancel", Endpoint: "cancel"}},
+		{{PathTemplate: "/v1/order/{id}/cancel", Service: "com.HailoOSS.api.order"}},
+		{{PathTemplate: "v1/order/{id}/cancel", Service: "com.HailoOSS.api.order", Endpoint: "cancel"}},
+		{{PathTemplate: "/v1/order/{id}/{id}", Service: "com.HailoOSS.api.order", Endpoint: "cancel"}},
+	}
+	for i, hr := range invalid {
+		assert.Error(t, hr.Compile(), "Case %d", i)
+	}
+}
+
+func TestH2RoutesFind(t *testing.T) {
+	hr := H2Routes{
+		{PathTemplate: "/v1/order/quote", Service: "com.HailoOSS.api.quote", Endpoint: "create"},
+		{PathTemplate: "/v1/order/{id}", Service: "com.HailoOSS.api.order", Endpoint: "read"},
+		{PathTemplate: "/v1/order/{id}/driver/{driver}", Service: "com.HailoOSS.api.order", Endpoint: "driver"},
+	}
+	assert.NoError(t, hr.Compile())
+
+	cases := []struct {
+		path, endpoint string
+		params         map[string]string
+	}{
+		{"/v1/order/quote", "create", nil}, // the first route that matches wins
+		{"/v1/order/123", "read", map[string]string{"id": "123"}},
+		{"/v1/order/123/", "read", map[string]string{"id": "123"}},
+		{"/v1/order/123/driver/456", "driver", map[string]string{"id": "123", "driver": "456"}},
+		{"/v1/order/123/cancel", "", nil},
+		{"/v1/order", "", nil},
+	}
+	for _, tc := range cases {
+		r, params := hr.find(tc.path)
+		if tc.endpoint == "" {
+			assert.Nil(t, r, "Path %s", tc.path)
+			continue
+		}
+		if assert.NotNil(t, r, "Path %s", tc.path) {
+			assert.Equal(t, tc.endpoint, r.Endpoint, "Path %s", tc.path)
+			assert.Equal(t, tc.params, params, "Path %s", tc.path)
+		}
+	}
+}
+
+func TestLoadH2Routes(t *testing.T) {
+	cp := &ControlPlane{}
+	_, _, _, ok := cp.H2Endpoint("/v1/order/123")
+	assert.False(t, ok, "Expecting no H2 routes before config is loaded")
+
+	err := cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":2,"match":{"path":"/v1/order","proportion":1}}},
+		"h2Routes":[{"pathTemplate":"/v1/order/{id}","service":"com.HailoOSS.api.order","endpoint":"read"}]}}`), "pinned")
+	assert.NoError(t, err)
+
+	service, endpoint, params, ok := cp.H2Endpoint("/v1/order/123")
+	assert.True(t, ok)
+	assert.Equal(t, "com.HailoOSS.api.order", service)
+	assert.Equal(t, "read", endpoint)
+	assert.Equal(t, map[string]string{"id": "123"}, params)
+
+	r, _ := http.NewRequest("GET", "http://localhost/v1/order/123", nil)
+	service, _, _, ok = cp.Router(r).H2Endpoint(r.URL.Path)
+	assert.True(t, ok)
+	assert.Equal(t, "com.HailoOSS.api.order", service)
+
+	// changes show up in the history
+	err = cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":2,"match":{"path":"/v1/order","proportion":1}}},
+		"h2Routes":[{"pathTemplate":"/v1/order/{id}","service":"com.HailoOSS.api.order","endpoint":"get"}]}}`), "pinned")
+	assert.NoError(t, err)
+	history := cp.History()
+	if assert.NotEmpty(t, history) {
+		assert.Equal(t, &ValueChange{From: "com.HailoOSS.api.order.read", To: "com.HailoOSS.api.order.get"},
+			history[0].Diff.H2Routes["/v1/order/{id}"])
+	}
+
+	// invalid routes aren't loaded
+	err = cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":2,"match":{"path":"/v1/order","proportion":1}}},
+		"h2Routes":[{"pathTemplate":"/v1/order/{id}","service":"com.HailoOSS.api.order"}]}}`), "pinned")
+	assert.Error(t, err)
+	_, endpoint, _, _ = cp.H2Endpoint("/v1/order/123")
+	assert.Equal(t, "get", endpoint, "Expecting the previous config to still be loaded")
+}
+
+func TestLintH2Routes(t *testing.T) {
+	problems := Lint([]byte(`{"controlPlane":{
+		"rules":{"a":{"action":2,"match":{"path":"/v1/order","proportion":1}}},
+		"h2Routes":[
+			{"pathTemplate":"/v1/order/{id}","service":"com.HailoOSS.api.order","endpoint":"read"},
+			{"pathTemplate":"/v1/order/quote","service":"com.HailoOSS.api.quote","endpoint":"create"},
+			{"pathTemplate":"/v1/quote/{id}","service":"com.HailoOSS.api.quote","endpoint":"read"}
+		],
+		` + lintRegionsJson + `}}`))
+	assert.False(t, problems.HasErrors())
+	assert.Nil(t, problemAt(problems, "$.controlPlane.h2Routes[0]"))
+	// /v1/order/{id} always matches first
+	assert.NotNil(t, problemAt(problems, "$.controlPlane.h2Routes[1]"))
+	assert.Nil(t, problemAt(problems, "$.controlPlane.h2Routes[2]"))
+
+	// and routes that won't load stop the config loading
+	problems = Lint([]byte(`{"controlPlane":{
+		"rules":{"a":{"action":2,"match":{"path":"/v1/order","proportion":1}}},
+		"h2Routes":[{"pathTemplate":"/v1/order/{id}/cancel","service":"com.HailoOSS.api.order"}],
+		` + lintRegionsJson + `}}`))
+	p := problemAt(problems, "$.controlPlane")
+	if assert.NotNil(t, p, "Expecting a problem; got %v", problems) {
+		assert.Contains(t, p.Message, "H2 route 0")
+	}
+}
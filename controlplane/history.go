@@ -0,0 +1,208 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/service/config"
+	inst "github.com/HailoOSS/service/instrumentation"
+)
+
+const (
+	defaultHistorySize = 10
+	configChanged      = "controlplane.config.changed"
+)
+
+// A ConfigGeneration is a version of config we loaded, and what changed from the one before
+type ConfigGeneration struct {
+	Generation    int64       `json:"generation"` // Generation counts up from 1 for the first config loaded
+	Loaded        time.Time   `json:"loaded"`
+	Hash          string      `json:"hash"`
+	ConfigVersion int64       `json:"configVersion"`
+	Pinned        string      `json:"pinned,omitempty"` // Pinned is the "last good" config generation loaded, if pinned
+	Diff          *ConfigDiff `json:"diff"`
+}
+
+// A ConfigDiff describes what changed between two generations of config. Rule IDs are hashes of their content, so a
+// changed rule appears as one rule removed and another added
+type ConfigDiff struct {
+	RulesAdded    map[string]*Rule           `json:"rulesAdded,omitempty"`
+	RulesRemoved  map[string]*Rule           `json:"rulesRemoved,omitempty"`
+	Regions       map[string]*RegionChange   `json:"regions,omitempty"`      // added, removed or changed regions, by ID
+	HobRegions    map[string]*ValueChange    `json:"hobRegions,omitempty"`   // HOBs moved between regions
+	HobModes      map[string]*ValueChange    `json:"hobModes,omitempty"`     // HOBs moved between modes
+	HobTimezones  map[string]*ValueChange    `json:"hobTimezones,omitempty"` // HOBs moved between timezones
+	HobGroups     map[string]*ValueChange    `json:"hobGroups,omitempty"`    // HOB groups changed, as CSVs
+	HostHobs      map[string]*ValueChange    `json:"hostHobs,omitempty"`     // host patterns moved between HOBs
+	Upstreams     map[string]*UpstreamChange `json:"upstreams,omitempty"`    // added, removed or changed upstreams, by name
+	H2Routes      map[string]*ValueChange    `json:"h2Routes,omitempty"`     // path templates moved between H2 endpoints
+	ConfigVersion *ValueChange               `json:"configVersion,omitempty"`
+}
+
+// A RegionChange describes a region before and after a change; From is nil for added regions, and To for removed
+type RegionChange struct {
+	From *Region `json:"from"`
+	To   *Region `json:"to"`
+}
+
+// An UpstreamChange describes an upstream before and after a change; From is nil for added upstreams, and To for
+// removed
+type UpstreamChange struct {
+	From *Upstream `json:"from"`
+	To   *Upstream `json:"to"`
+}
+
+// A ValueChange describes a single value before and after a change; blank for values added or removed
+type ValueChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Empty tells us if nothing changed
+func (d *ConfigDiff) Empty() bool {
+	return len(d.RulesAdded) == 0 && len(d.RulesRemoved) == 0 && len(d.Regions) == 0 && len(d.HobRegions) == 0 &&
+		len(d.HobModes) == 0 && len(d.HobTimezones) == 0 && len(d.HobGroups) == 0 && len(d.HostHobs) == 0 &&
+		len(d.Upstreams) == 0 && len(d.H2Routes) == 0 && d.ConfigVersion == nil
+}
+
+// diffConfigs works out what changed from one generation of config to another
+func diffConfigs(from, to loadedCpConfig) *ConfigDiff {
+	d := &ConfigDiff{
+		RulesAdded:   make(map[string]*Rule),
+		RulesRemoved: make(map[string]*Rule),
+		Regions:      make(map[string]*RegionChange),
+		Upstreams:    make(map[string]*UpstreamChange),
+	}
+
+	fromRules := make(map[string]*Rule, len(from.rules))
+	for _, r := range from.rules {
+		fromRules[r.Id()] = r
+	}
+	for _, r := range to.rules {
+		id := r.Id()
+		if _, ok := fromRules[id]; ok {
+			delete(fromRules, id)
+		} else {
+			d.RulesAdded[id] = r
+		}
+	}
+	d.RulesRemoved = fromRules
+
+	for id, fr := range from.regions {
+		if tr := to.regions[id]; !reflect.DeepEqual(fr, tr) {
+			d.Regions[id] = &RegionChange{From: fr, To: tr}
+		}
+	}
+	for id, tr := range to.regions {
+		if _, ok := from.regions[id]; !ok {
+			d.Regions[id] = &RegionChange{To: tr}
+		}
+	}
+
+	for name, fu := range from.upstreams {
+		if tu := to.upstreams[name]; !reflect.DeepEqual(fu, tu) {
+			d.Upstreams[name] = &UpstreamChange{From: fu, To: tu}
+		}
+	}
+	for name, tu := range to.upstreams {
+		if _, ok := from.upstreams[name]; !ok {
+			d.Upstreams[name] = &UpstreamChange{To: tu}
+		}
+	}
+
+	d.HobRegions = diffStringMaps(from.hobRegions, to.hobRegions)
+	d.HobModes = diffStringMaps(from.hobModes, to.hobModes)
+	d.HobTimezones = diffStringMaps(from.hobLocations.names(), to.hobLocations.names())
+	d.HobGroups = diffStringMaps(from.hobGroups.csvs(), to.hobGroups.csvs())
+	d.HostHobs = diffStringMaps(from.hostHobs.patterns(), to.hostHobs.patterns())
+	d.H2Routes = diffStringMaps(from.h2Routes.endpoints(), to.h2Routes.endpoints())
+
+	if from.rConfigVersion != to.rConfigVersion {
+		d.ConfigVersion = &ValueChange{
+			From: formatVersion(from.rConfigVersion),
+			To:   formatVersion(to.rConfigVersion),
+		}
+	}
+
+	return d
+}
+
+func diffStringMaps(from, to map[string]string) map[string]*ValueChange {
+	result := make(map[string]*ValueChange)
+	for k, fv := range from {
+		if tv := to[k]; fv != tv {
+			result[k] = &ValueChange{From: fv, To: tv}
+		}
+	}
+	for k, tv := range to {
+		if _, ok := from[k]; !ok {
+			result[k] = &ValueChange{To: tv}
+		}
+	}
+	return result
+}
+
+func formatVersion(v int64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// names returns the names of the locations, indexed by HOB
+func (hl hobLocations) names() map[string]string {
+	result := make(map[string]string, len(hl))
+	for hob, loc := range hl {
+		result[hob] = loc.String()
+	}
+	return result
+}
+
+// recordGeneration works out what changed between the config we had and the config we've just loaded, emitting an
+// event and remembering it (and the last few before it) for History
+func (cp *ControlPlane) recordGeneration(from, to loadedCpConfig, pinned string) *ConfigGeneration {
+	gen := &ConfigGeneration{
+		Loaded:        time.Now(),
+		Hash:          to.configHash,
+		ConfigVersion: to.rConfigVersion,
+		Pinned:        pinned,
+		Diff:          diffConfigs(from, to),
+	}
+
+	size := config.AtPath("hailo", "api", "controlPlane", "historySize").AsInt(defaultHistorySize)
+
+	cp.historyLock.Lock()
+	cp.generation++
+	gen.Generation = cp.generation
+	cp.history = append(cp.history, gen)
+	if size < 1 {
+		size = 1
+	}
+	if len(cp.history) > size {
+		cp.history = append([]*ConfigGeneration(nil), cp.history[len(cp.history)-size:]...)
+	}
+	cp.historyLock.Unlock()
+
+	inst.Counter(1.0, configChanged, 1)
+	if b, err := json.Marshal(gen); err == nil {
+		log.Infof("[Control Plane] Config changed: %s", b)
+	}
+
+	return gen
+}
+
+// History returns the last few generations of config loaded, newest first
+func (cp *ControlPlane) History() []*ConfigGeneration {
+	cp.historyLock.RLock()
+	defer cp.historyLock.RUnlock()
+
+	result := make([]*ConfigGeneration, len(cp.history))
+	for i, gen := range cp.history {
+		result[len(cp.history)-1-i] = gen
+	}
+	return result
+}
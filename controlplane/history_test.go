@@ -0,0 +1,103 @@
+package controlplane
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/service/config"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	kept := &Rule{Action: ActionProxyToH1, Match: &Match{Path: "/v1/order", Proportion: 1}}
+	removed := &Rule{Action: ActionProxyToH1, Match: &Match{Path: "/v1/point", Proportion: 1}}
+	added := &Rule{Action: ActionSendToH2, Match: &Match{Path: "/v1/point", Proportion: 1}}
+	london, _ := time.LoadLocation("Europe/London")
+
+	from := loadedCpConfig{
+		rules: SortedRules{kept, removed},
+		regions: Regions{
+			"eu-west-1": {Id: "eu-west-1", Status: "ONLINE"},
+			"us-east-1": {Id: "us-east-1", Status: "ONLINE"},
+		},
+		hobRegions:     HobRegions{"LON": "eu-west-1", "BOS": "us-east-1"},
+		hobModes:       HobModes{"LON": "h1"},
+		rConfigVersion: 1,
+	}
+	to := loadedCpConfig{
+		rules: SortedRules{kept, added},
+		regions: Regions{
+			"eu-west-1":      {Id: "eu-west-1", Status: "OFFLINE"},
+			"us-east-1":      {Id: "us-east-1", Status: "ONLINE"},
+			"ap-northeast-1": {Id: "ap-northeast-1"},
+		},
+		hobRegions:     HobRegions{"LON": "us-east-1", "BOS": "us-east-1"},
+		hobModes:       HobModes{"LON": "h2", "TYO": "h2"},
+		hobLocations:   hobLocations{"LON": london},
+		rConfigVersion: 2,
+	}
+
+	d := diffConfigs(from, to)
+	assert.False(t, d.Empty())
+	assert.Equal(t, map[string]*Rule{added.Id(): added}, d.RulesAdded)
+	assert.Equal(t, map[string]*Rule{removed.Id(): removed}, d.RulesRemoved)
+
+	assert.Len(t, d.Regions, 2)
+	if assert.NotNil(t, d.Regions["eu-west-1"]) {
+		assert.Equal(t, "ONLINE", d.Regions["eu-west-1"].From.Status)
+		assert.Equal(t, "OFFLINE", d.Regions["eu-west-1"].To.Status)
+	}
+	if assert.NotNil(t, d.Regions["ap-northeast-1"]) {
+		assert.Nil(t, d.Regions["ap-northeast-1"].From)
+	}
+
+	assert.Equal(t, map[string]*ValueChange{"LON": {From: "eu-west-1", To: "us-east-1"}}, d.HobRegions)
+	assert.Equal(t, map[string]*ValueChange{"LON": {From: "h1", To: "h2"}, "TYO": {To: "h2"}}, d.HobModes)
+	assert.Equal(t, map[string]*ValueChange{"LON": {To: "Europe/London"}}, d.HobTimezones)
+	assert.Equal(t, &ValueChange{From: "1", To: "2"}, d.ConfigVersion)
+
+	assert.True(t, diffConfigs(to, to).Empty())
+}
+
+func TestHistory(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	loadRules()
+	cp := New()
+
+	history := cp.History()
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, int64(1), history[0].Generation)
+		assert.Equal(t, cp.currentHash(), history[0].Hash)
+		assert.Len(t, history[0].Diff.RulesAdded, len(cp.Rules()))
+	}
+
+	// move LON to us-east-1
+	config.Load(bytes.NewBufferString(`{"controlPlane":{"configVersion":2,` +
+		`"regions":{"eu-west-1":{"id":"eu-west-1"},"us-east-1":{"id":"us-east-1"}},` +
+		`"hobRegions":{"LON":"us-east-1"},` +
+		`"rules":{"a":{"action":2,"match":{"path":"/v1/point","proportion":1}}}}}`))
+	cp.loadCycle()
+
+	history = cp.History()
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, int64(2), history[0].Generation, "Expecting the newest generation first")
+		assert.Equal(t, cp.currentHash(), history[0].Hash)
+		assert.Equal(t, &ValueChange{From: "eu-west-1", To: "us-east-1"}, history[0].Diff.HobRegions["LON"])
+		assert.Len(t, history[0].Diff.RulesAdded, 1)
+	}
+}
+
+func TestHistorySize(t *testing.T) {
+	cp := &ControlPlane{}
+	for i := 0; i < defaultHistorySize+5; i++ {
+		cp.recordGeneration(loadedCpConfig{}, loadedCpConfig{}, "")
+	}
+
+	history := cp.History()
+	assert.Len(t, history, defaultHistorySize)
+	assert.Equal(t, int64(defaultHistorySize+5), history[0].Generation)
+	assert.Equal(t, int64(6), history[len(history)-1].Generation)
+}
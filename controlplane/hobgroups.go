@@ -0,0 +1,108 @@
+package controlplane
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// hobGroupPrefix marks a reference to a HOB group in a match's regulatoryArea, like @EU_H1_CITIES
+	hobGroupPrefix = "@"
+)
+
+// hobGroupName is what HOB groups may be called
+var hobGroupName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Validate checks every HOB group has a sensible name and lists at least one HOB (and not other groups)
+func (hg HobGroups) Validate() error {
+	for _, name := range hg.names() {
+		if !hobGroupName.MatchString(name) {
+			return fmt.Errorf("Invalid HOB group name %q; must be letters, digits and underscores", name)
+		}
+		if len(hg[name]) == 0 {
+			return fmt.Errorf("HOB group %s must have at least one HOB", name)
+		}
+		for _, hob := range hg[name] {
+			if len(hob) == 0 || strings.HasPrefix(hob, hobGroupPrefix) || strings.Contains(hob, ",") {
+				return fmt.Errorf("HOB group %s has an invalid HOB %q", name, hob)
+			}
+		}
+	}
+	return nil
+}
+
+// csvs returns the HOBs of each group as a CSV, indexed by name
+func (hg HobGroups) csvs() map[string]string {
+	result := make(map[string]string, len(hg))
+	for name, hobs := range hg {
+		result[name] = strings.Join(hobs, ",")
+	}
+	return result
+}
+
+func (hg HobGroups) names() []string {
+	names := make([]string, 0, len(hg))
+	for name := range hg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compileHobs parses the regulatory area CSV of a match into a set, noting any HOB groups it refers to so they can
+// be expanded by expandHobGroups
+func (m *Match) compileHobs() {
+	m.hobs, m.hobGroups = nil, nil
+	if len(m.Hob) == 0 {
+		return
+	}
+
+	m.hobs = make(map[string]bool)
+	for _, v := range strings.Split(m.Hob, ",") {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, hobGroupPrefix) {
+			m.hobGroups = append(m.hobGroups, v[len(hobGroupPrefix):])
+		} else if len(v) > 0 {
+			m.hobs[v] = true
+		}
+	}
+}
+
+// expandHobGroups adds the HOBs of the groups a match refers to into its set, returning an error if it refers to a
+// group that doesn't exist
+func (m *Match) expandHobGroups(hg HobGroups) error {
+	for _, name := range m.hobGroups {
+		hobs, ok := hg[name]
+		if !ok {
+			return fmt.Errorf("Unknown HOB group %s%s", hobGroupPrefix, name)
+		}
+		for _, hob := range hobs {
+			m.hobs[hob] = true
+		}
+	}
+	return nil
+}
+
+// ExpandHobGroups expands the HOB groups referred to by every (compiled) rule, checking they exist
+func (s SortedRules) ExpandHobGroups(hg HobGroups) error {
+	for _, r := range s {
+		if r == nil || r.Match == nil {
+			continue
+		}
+		if err := r.Match.expandHobGroups(hg); err != nil {
+			return fmt.Errorf("Rule %s: %v", r.Id(), err)
+		}
+	}
+	return nil
+}
+
+// matchesHob tests if a HOB is within the match's regulatory area. If the match hasn't been compiled we make do with
+// the raw CSV (which can't refer to groups)
+func (m *Match) matchesHob(hob string) bool {
+	if m.hobs == nil {
+		return withinCsv(m.Hob, hob)
+	}
+	return m.hobs[hob]
+}
@@ -0,0 +1,105 @@
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHobGroupsValidation(t *testing.T) {
+	valid := []HobGroups{
+		nil,
+		{"EU_H1_CITIES": {"LON", "DUB"}, "US": {"NYC"}},
+	}
+	for i, hg := range valid {
+		assert.NoError(t, hg.Validate(), "Case %d", i)
+	}
+
+	invalid := []HobGroups{
+		{"EU CITIES": {"LON"}},
+		{"EU": {}},
+		{"EU": {"LON", ""}},
+		{"EU": {"LON,DUB"}},
+		{"EU": {"LON"}, "ALL": {"@EU", "NYC"}},
+	}
+	for i, hg := range invalid {
+		assert.Error(t, hg.Validate(), "Case %d", i)
+	}
+}
+
+func TestHobGroupMatching(t *testing.T) {
+	hg := HobGroups{"EU": {"LON", "DUB"}, "US": {"NYC", "BOS"}}
+	m := &Match{Hob: "@EU, CHI", Proportion: 1}
+	assert.NoError(t, m.compile())
+	assert.NoError(t, m.expandHobGroups(hg))
+
+	for hob, matches := range map[string]bool{"LON": true, "DUB": true, "CHI": true, "NYC": false, "": false} {
+		assert.Equal(t, matches, m.mismatch(&testExtractor{hob: hob}) == "", "HOB %s", hob)
+	}
+
+	// groups must exist
+	m = &Match{Hob: "@EU,@ASIA", Proportion: 1}
+	assert.NoError(t, m.compile())
+	assert.Error(t, m.expandHobGroups(hg))
+
+	// uncompiled matches fall back to the CSV
+	assert.Equal(t, "", (&Match{Hob: "LON,DUB", Proportion: 1}).mismatch(&testExtractor{hob: "DUB"}))
+}
+
+func TestLoadHobGroups(t *testing.T) {
+	cp := &ControlPlane{}
+	err := cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":1,"match":{"regulatoryArea":"@EU_H1_CITIES","proportion":1}}},
+		"hobGroups":{"EU_H1_CITIES":["LON","DUB"]}}}`), "pinned")
+	assert.NoError(t, err)
+	rule := cp.loadedConfig().rules[0]
+	assert.True(t, rule.Match.matchesHob("DUB"))
+	assert.False(t, rule.Match.matchesHob("NYC"))
+
+	// changing a group reloads (and expands) the rules, and shows up in the history
+	err = cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":1,"match":{"regulatoryArea":"@EU_H1_CITIES","proportion":1}}},
+		"hobGroups":{"EU_H1_CITIES":["LON","DUB","MAD"]}}}`), "pinned")
+	assert.NoError(t, err)
+	assert.True(t, cp.loadedConfig().rules[0].Match.matchesHob("MAD"))
+	history := cp.History()
+	if assert.NotEmpty(t, history) {
+		assert.Equal(t, &ValueChange{From: "LON,DUB", To: "LON,DUB,MAD"},
+			history[0].Diff.HobGroups["EU_H1_CITIES"])
+	}
+
+	// rules must refer to groups that exist
+	err = cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":1,"match":{"regulatoryArea":"@US_CITIES","proportion":1}}},
+		"hobGroups":{"EU_H1_CITIES":["LON","DUB"]}}}`), "pinned")
+	assert.Error(t, err)
+}
+
+func TestLintHobGroups(t *testing.T) {
+	problems := Lint([]byte(`{"controlPlane":{
+		"rules":{
+			"a":{"action":1,"match":{"regulatoryArea":"@EU","path":"/v1/order","proportion":1}},
+			"c":{"action":2,"match":{"regulatoryArea":"LON","path":"/v1/order/quote","proportion":1}}
+		},
+		"hobGroups":{"EU":["LON","DUB"],"US":["NYC"]},
+		` + lintRegionsJson + `}}`))
+	assert.False(t, problems.HasErrors())
+	assert.Nil(t, problemAt(problems, "$.controlPlane.rules.a"))
+	assert.NotNil(t, problemAt(problems, "$.controlPlane.hobGroups.US"))
+	assert.Nil(t, problemAt(problems, "$.controlPlane.hobGroups.EU"))
+	// LON is in EU, so a (tied with c, but first by key) always matches before c
+	assert.NotNil(t, problemAt(problems, "$.controlPlane.rules.c"))
+
+	// and unknown groups stop the config loading
+	problems = Lint([]byte(`{"controlPlane":{
+		"rules":{"b":{"action":1,"match":{"regulatoryArea":"@ASIA","path":"/v1/point","proportion":1}}},
+		"hobGroups":{"EU":["LON","DUB"]},
+		` + lintRegionsJson + `}}`))
+	p := problemAt(problems, "$.controlPlane")
+	if assert.NotNil(t, p, "Expecting a problem; got %v", problems) {
+		assert.Contains(t, p.Message, "Unknown HOB group @ASIA")
+	}
+}
@@ -0,0 +1,139 @@
+package controlplane
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	hostHobWildcard = "*."
+	// builtInHostHobs is the pattern we report for HOBs found from the built-in hostMap
+	builtInHostHobs = "built-in"
+)
+
+// hostHobMatcher finds the HOB for a hostname from the host HOBs in config: exact hostnames first, then the most
+// specific wildcard, then regexes in order
+type hostHobMatcher struct {
+	exact     map[string]string
+	wildcards []*HostHob // longest first
+	regexes   []*HostHob
+}
+
+// Compile validates the host HOBs, compiling them into a matcher (or nil if there aren't any)
+func (hh HostHobs) Compile() (*hostHobMatcher, error) {
+	if len(hh) == 0 {
+		return nil, nil
+	}
+
+	m := &hostHobMatcher{exact: make(map[string]string)}
+	for i, h := range hh {
+		if h == nil {
+			return nil, fmt.Errorf("Host HOB %d is missing", i)
+		}
+		if err := h.compile(); err != nil {
+			return nil, fmt.Errorf("Host HOB %d: %v", i, err)
+		}
+		switch {
+		case h.regex != nil:
+			m.regexes = append(m.regexes, h)
+		case strings.HasPrefix(h.Host, hostHobWildcard):
+			m.wildcards = append(m.wildcards, h)
+		default:
+			host := strings.ToLower(h.Host)
+			if _, ok := m.exact[host]; ok {
+				return nil, fmt.Errorf("Host HOB %d: %s is mapped more than once", i, h.Host)
+			}
+			m.exact[host] = h.Hob
+		}
+	}
+	sort.Stable(byHostLength(m.wildcards))
+	return m, nil
+}
+
+func (h *HostHob) compile() error {
+	h.regex = nil
+	if len(h.Hob) == 0 {
+		return fmt.Errorf("Must have a HOB")
+	}
+	if len(h.Host) > 0 && len(h.Regex) > 0 {
+		return fmt.Errorf("Must have a host or a regex, not both")
+	}
+	if len(h.Regex) > 0 {
+		re, err := regexp.Compile(h.Regex)
+		if err != nil {
+			return fmt.Errorf("Invalid regex %q: %v", h.Regex, err)
+		}
+		h.regex = re
+		return nil
+	}
+	if len(h.Host) == 0 {
+		return fmt.Errorf("Must have a host or a regex")
+	}
+	if strings.Contains(strings.TrimPrefix(h.Host, hostHobWildcard), "*") || h.Host == hostHobWildcard {
+		return fmt.Errorf("Invalid host %q; wildcards must be like *.example.com", h.Host)
+	}
+	return nil
+}
+
+// pattern is how we describe a host HOB: its host or regex
+func (h *HostHob) pattern() string {
+	if len(h.Regex) > 0 {
+		return h.Regex
+	}
+	return h.Host
+}
+
+type byHostLength []*HostHob
+
+func (s byHostLength) Len() int           { return len(s) }
+func (s byHostLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byHostLength) Less(i, j int) bool { return len(s[i].Host) > len(s[j].Host) }
+
+// find returns the HOB for a hostname (ignoring any port, and case), and the pattern that matched it
+func (m *hostHobMatcher) find(host string) (hob, pattern string) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if hob, ok := m.exact[host]; ok {
+		return hob, host
+	}
+	for _, h := range m.wildcards {
+		if strings.HasSuffix(host, strings.ToLower(h.Host[1:])) {
+			return h.Hob, h.Host
+		}
+	}
+	for _, h := range m.regexes {
+		if h.regex.MatchString(host) {
+			return h.Hob, h.Regex
+		}
+	}
+	return "", ""
+}
+
+// patterns returns the HOB of every host HOB, indexed by pattern
+func (hh HostHobs) patterns() map[string]string {
+	result := make(map[string]string, len(hh))
+	for _, h := range hh {
+		if h != nil {
+			result[h.pattern()] = h.Hob
+		}
+	}
+	return result
+}
+
+// hobForHost finds the HOB for a hostname from the host HOBs in config or, if there aren't any, the built-in hostMap.
+// It returns the pattern that matched too
+func hobForHost(m *hostHobMatcher, host string) (hob, pattern string) {
+	if m != nil {
+		return m.find(host)
+	}
+	if hob, ok := hostMap[host]; ok {
+		return hob, builtInHostHobs
+	}
+	return "", ""
+}
@@ -0,0 +1,101 @@
+package controlplane
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostHobsCompile(t *testing.T) {
+	valid := []HostHobs{
+		nil,
+		{{Host: "api-driver-london.elasticride.com", Hob: "LON"}},
+		{{Host: "*.dublin.elasticride.com", Hob: "DUB"}, {Regex: `^api-driver-nyc(-test)?\.`, Hob: "NYC"}},
+	}
+	for i, hh := range valid {
+		_, err := hh.Compile()
+		assert.NoError(t, err, "Case %d", i)
+	}
+
+	invalid := []HostHobs{
+		{nil},
+		{{Host: "api-driver-london.elasticride.com"}},
+		{{Hob: "LON"}},
+		{{Host: "api-driver-london.elasticride.com", Regex: "london", Hob: "LON"}},
+		{{Regex: "(", Hob: "LON"}},
+		{{Host: "api-*.elasticride.com", Hob: "LON"}},
+		{{Host: "*.", Hob: "LON"}},
+		{{Host: "api.elasticride.com", Hob: "LON"}, {Host: "API.elasticride.com", Hob: "DUB"}},
+	}
+	for i, hh := range invalid {
+		_, err := hh.Compile()
+		assert.Error(t, err, "Case %d", i)
+	}
+}
+
+func TestHostHobFind(t *testing.T) {
+	m, err := HostHobs{
+		{Regex: `^api-driver-(nyc|newyork)(-test)?\.`, Hob: "NYC"},
+		{Host: "*.elasticride.com", Hob: "LON"},
+		{Host: "*.dublin.elasticride.com", Hob: "DUB"},
+		{Host: "api.dublin.elasticride.com", Hob: "ORK"},
+	}.Compile()
+	assert.NoError(t, err)
+
+	cases := []struct {
+		host, hob, pattern string
+	}{
+		{"api.dublin.elasticride.com", "ORK", "api.dublin.elasticride.com"}, // exact beats wildcards
+		{"API.Dublin.elasticride.com:443", "ORK", "api.dublin.elasticride.com"},
+		{"x.dublin.elasticride.com", "DUB", "*.dublin.elasticride.com"}, // the most specific wildcard wins
+		{"api-driver-nyc.elasticride.com", "LON", "*.elasticride.com"},  // wildcards beat regexes
+		{"api-driver-newyork-test.example.com", "NYC", `^api-driver-(nyc|newyork)(-test)?\.`},
+		{"elasticride.com", "", ""}, // wildcards only match subdomains
+		{"api.example.com", "", ""},
+	}
+	for _, tc := range cases {
+		hob, pattern := hobForHost(m, tc.host)
+		assert.Equal(t, tc.hob, hob, "Host %s", tc.host)
+		assert.Equal(t, tc.pattern, pattern, "Host %s", tc.host)
+	}
+
+	// without any host HOBs in config, we use the built-in list
+	hob, pattern := hobForHost(nil, "api-driver-dublin.elasticride.com")
+	assert.Equal(t, "DUB", hob)
+	assert.Equal(t, builtInHostHobs, pattern)
+}
+
+func TestLoadHostHobs(t *testing.T) {
+	cp := &ControlPlane{}
+	err := cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":1,"match":{"regulatoryArea":"MAN","proportion":1}}},
+		"hostHobs":[{"host":"*.manchester.elasticride.com","hob":"MAN"}]}}`), "pinned")
+	assert.NoError(t, err)
+
+	r, _ := http.NewRequest("GET", "http://api-driver.manchester.elasticride.com/v1/point", nil)
+	assert.Equal(t, ActionProxyToH1, cp.Router(r).Route().Action)
+
+	// routing adds the HOB to the query, so explain a fresh request
+	r, _ = http.NewRequest("GET", "http://api-driver.manchester.elasticride.com/v1/point", nil)
+	e := cp.Explain(r)
+	assert.Equal(t, "MAN", e.Hob)
+	assert.Equal(t, "host *.manchester.elasticride.com", e.HobSource)
+
+	r, _ = http.NewRequest("GET", "http://api-driver.manchester.elasticride.com/v1/point?city=LON", nil)
+	assert.Equal(t, "param", cp.Explain(r).HobSource)
+
+	// the config replaces the built-in list
+	r, _ = http.NewRequest("GET", "http://api-driver-london.elasticride.com/v1/point", nil)
+	assert.Equal(t, "", cp.Explain(r).Hob)
+
+	// invalid host HOBs aren't loaded
+	err = cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":1,"match":{"regulatoryArea":"MAN","proportion":1}}},
+		"hostHobs":[{"regex":"(","hob":"MAN"}]}}`), "pinned")
+	assert.Error(t, err)
+	r, _ = http.NewRequest("GET", "http://api-driver.manchester.elasticride.com/v1/point", nil)
+	assert.Equal(t, "MAN", cp.Explain(r).Hob, "Expecting the previous config to still be loaded")
+}
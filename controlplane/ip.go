@@ -0,0 +1,53 @@
+package controlplane
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// compileIPs parses the IP addresses and CIDR ranges of a match, if any, storing them for use when matching
+func (m *Match) compileIPs() error {
+	m.ipNets = nil
+	if len(m.IPs) == 0 {
+		return nil
+	}
+
+	nets := make([]*net.IPNet, len(m.IPs))
+	for i, s := range m.IPs {
+		if !strings.Contains(s, "/") {
+			// a single address
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("Invalid IP %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets[i] = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("Invalid CIDR %q: %v", s, err)
+		}
+		nets[i] = ipNet
+	}
+	m.ipNets = nets
+
+	return nil
+}
+
+// matchesIP tests if an IP is within any of the match's ranges
+func (m *Match) matchesIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range m.ipNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package controlplane
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPValidation(t *testing.T) {
+	valid := [][]string{
+		{"10.0.0.0/8"},
+		{"192.168.1.10", "2001:db8::/32", "::1"},
+	}
+	for i, ips := range valid {
+		assert.NoError(t, (&Match{IPs: ips}).compile(), "Case %d", i)
+	}
+
+	invalid := [][]string{
+		{"10.0.0.0/33"},
+		{"10.0.0"},
+		{"office"},
+		{"2001:db8::/129"},
+	}
+	for i, ips := range invalid {
+		assert.Error(t, (&Match{IPs: ips}).compile(), "Case %d", i)
+	}
+}
+
+func TestIPMatching(t *testing.T) {
+	m := &Match{IPs: []string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32"}, Proportion: 1}
+	assert.NoError(t, m.compile())
+
+	cases := []struct {
+		ip      string
+		matches bool
+	}{
+		{"10.1.2.3", true},
+		{"11.1.2.3", false},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"::ffff:10.1.2.3", true}, // IPv4 mapped IPv6
+	}
+	for _, tc := range cases {
+		ext := &testExtractor{remoteIP: net.ParseIP(tc.ip)}
+		assert.Equal(t, tc.matches, m.mismatch(ext) == "", "IP %s", tc.ip)
+	}
+
+	// without an IP, we can't match
+	assert.Equal(t, mismatchIP, m.mismatch(&testExtractor{}))
+}
+
+func TestRemoteIP(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"10.1.2.3:4567":      "10.1.2.3",
+		"[2001:db8::1]:4567": "2001:db8::1",
+		"10.1.2.3":           "10.1.2.3",
+		"":                   "<nil>",
+	} {
+		r, _ := http.NewRequest("GET", "/v1/point", nil)
+		r.RemoteAddr = addr
+		assert.Equal(t, expected, newExtractor(r).RemoteIP().String(), "RemoteAddr %q", addr)
+	}
+}
@@ -0,0 +1,196 @@
+package controlplane
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/service/config"
+)
+
+const (
+	lastGoodPrefix     = "api-proxy-config-"
+	lastGoodSuffix     = ".json"
+	lastGoodTimeFormat = "20060102T150405.000000000Z" // sorts lexicographically, so newest is last
+)
+
+var (
+	// LastGoodDir is the directory we keep generations of "last good" config in
+	LastGoodDir = "/opt/hailo/var/cache/api-proxy-config.d"
+	// LastGoodGenerations is how many generations of "last good" config we keep
+	LastGoodGenerations = 10
+)
+
+// A LastGoodGeneration is a "last good" config we saved to file, identified by the time it was saved
+type LastGoodGeneration struct {
+	Id    string    `json:"id"`
+	Saved time.Time `json:"saved"`
+	Size  int64     `json:"size"`
+}
+
+// LastGoodConfigs lists the generations of "last good" config we have on file, newest first
+func LastGoodConfigs() ([]*LastGoodGeneration, error) {
+	infos, err := ioutil.ReadDir(LastGoodDir)
+	if os.IsNotExist(err) {
+		return []*LastGoodGeneration{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := make([]*LastGoodGeneration, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, lastGoodPrefix) || !strings.HasSuffix(name, lastGoodSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, lastGoodPrefix), lastGoodSuffix)
+		saved, err := time.Parse(lastGoodTimeFormat, id)
+		if err != nil {
+			continue // not one of ours
+		}
+		result = append(result, &LastGoodGeneration{Id: id, Saved: saved, Size: info.Size()})
+	}
+
+	sort.Sort(sort.Reverse(lastGoodByTime(result)))
+	return result, nil
+}
+
+type lastGoodByTime []*LastGoodGeneration
+
+func (s lastGoodByTime) Len() int           { return len(s) }
+func (s lastGoodByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s lastGoodByTime) Less(i, j int) bool { return s[i].Saved.Before(s[j].Saved) }
+
+// readLastGood reads the generation of "last good" config with the given ID
+func readLastGood(id string) ([]byte, error) {
+	if _, err := time.Parse(lastGoodTimeFormat, id); err != nil {
+		return nil, fmt.Errorf("Invalid last good config generation %q", id)
+	}
+	return ioutil.ReadFile(lastGoodPath(id))
+}
+
+func lastGoodPath(id string) string {
+	return filepath.Join(LastGoodDir, lastGoodPrefix+id+lastGoodSuffix)
+}
+
+// LoadLastGoodConfig loads the newest "last good" config from file, falling back to the single file we used to keep
+func LoadLastGoodConfig() {
+	// try to load ONCE and never reload
+	fn := filename
+	if gens, err := LastGoodConfigs(); err != nil {
+		log.Errorf("[Control Plane] Failed to list last known configs: %v", err)
+	} else if len(gens) > 0 {
+		fn = lastGoodPath(gens[0].Id)
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Errorf("[Control Plane] Last known config load failed: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if err := config.Load(f); err != nil {
+		log.Errorf("[Control Plane] Last known config load failed: %v", err)
+		return
+	}
+	log.Infof("[Control Plane] Loaded last known config from '%v'", fn)
+}
+
+// saveConfigToFile will save config to file as a new generation, removing the oldest once we have too many -- this
+// is called after every successful load/validate cycle, so we know at this point the config is valid
+func (cp *ControlPlane) saveConfigToFile(c []byte) error {
+	if err := os.MkdirAll(LastGoodDir, 0755); err != nil {
+		return err
+	}
+
+	// Minimise the likelihood of borking an existing config by doing a write to a temp file and then a move
+	tmpFile, err := ioutil.TempFile(LastGoodDir, ".tmp-"+lastGoodPrefix)
+	if err != nil {
+		return err
+	}
+	tmpFile.Close()
+
+	if err := ioutil.WriteFile(tmpFile.Name(), c, 0644); err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("Error writing config file: %v", err)
+	}
+
+	// Shuffle the temp file to the new generation
+	fn := lastGoodPath(time.Now().UTC().Format(lastGoodTimeFormat))
+	if err := os.Rename(tmpFile.Name(), fn); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	log.Infof("[Control Plane] Last good config saved to '%v'", fn)
+
+	return pruneLastGood()
+}
+
+// pruneLastGood removes the oldest generations of "last good" config, so we only keep LastGoodGenerations of them
+func pruneLastGood() error {
+	gens, err := LastGoodConfigs()
+	if err != nil {
+		return err
+	}
+	keep := LastGoodGenerations
+	if keep < 1 {
+		keep = 1
+	}
+	for len(gens) > keep {
+		oldest := gens[len(gens)-1]
+		gens = gens[:len(gens)-1]
+		if err := os.Remove(lastGoodPath(oldest.Id)); err != nil {
+			return err
+		}
+		log.Debugf("[Control Plane] Removed last good config %s", oldest.Id)
+	}
+	return nil
+}
+
+// Pinned returns the generation of "last good" config we're pinned to, or "" if we're following the config service
+func (cp *ControlPlane) Pinned() string {
+	cp.pinLock.RLock()
+	defer cp.pinLock.RUnlock()
+	return cp.pinned
+}
+
+// Pin loads a generation of "last good" config, ignoring updates from the config service until we Unpin. It doesn't
+// wait for any reload loop retrying bad config from the config service (which is when we most need to pin), which
+// stops once it sees we're pinned
+func (cp *ControlPlane) Pin(id string) error {
+	rawConfig, err := readLastGood(id)
+	if err != nil {
+		return err
+	}
+
+	cp.loadLock.Lock()
+	defer cp.loadLock.Unlock()
+	if err := cp.load(rawConfig, id); err != nil {
+		return err
+	}
+
+	cp.pinLock.Lock()
+	cp.pinned = id
+	cp.pinLock.Unlock()
+	log.Warnf("[Control Plane] Pinned to last good config %s; ignoring config service updates until unpinned", id)
+	return nil
+}
+
+// Unpin goes back to following the config service, after a Pin
+func (cp *ControlPlane) Unpin() error {
+	cp.loadLock.Lock()
+	cp.pinLock.Lock()
+	cp.pinned = ""
+	cp.pinLock.Unlock()
+	cp.loadLock.Unlock()
+	log.Warnf("[Control Plane] Unpinned; following config service")
+
+	return cp.tryLoad()
+}
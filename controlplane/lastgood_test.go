@@ -0,0 +1,148 @@
+package controlplane
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/service/config"
+)
+
+// withLastGoodDir points "last good" config at a temporary directory for the duration of a test
+func withLastGoodDir(t *testing.T, generations int) func() {
+	dir, err := ioutil.TempDir("", "api-proxy-lastgood")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	origDir, origGenerations := LastGoodDir, LastGoodGenerations
+	LastGoodDir, LastGoodGenerations = dir, generations
+	return func() {
+		LastGoodDir, LastGoodGenerations = origDir, origGenerations
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSaveLastGoodGenerations(t *testing.T) {
+	defer withLastGoodDir(t, 3)()
+	cp := &ControlPlane{}
+
+	gens, err := LastGoodConfigs()
+	assert.NoError(t, err)
+	assert.Empty(t, gens)
+
+	for _, c := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`} {
+		assert.NoError(t, cp.saveConfigToFile([]byte(c)))
+	}
+
+	// we only keep the newest 3, newest first
+	gens, err = LastGoodConfigs()
+	assert.NoError(t, err)
+	if assert.Len(t, gens, 3) {
+		for i, expected := range []string{`{"n":5}`, `{"n":4}`, `{"n":3}`} {
+			b, err := readLastGood(gens[i].Id)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(b))
+		}
+		assert.True(t, gens[0].Saved.After(gens[1].Saved))
+	}
+
+	_, err = readLastGood("../../etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestLoadLastGoodConfig(t *testing.T) {
+	defer withLastGoodDir(t, 3)()
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+
+	cp := &ControlPlane{}
+	assert.NoError(t, cp.saveConfigToFile([]byte(`{"controlPlane":{"configVersion":1}}`)))
+	assert.NoError(t, cp.saveConfigToFile([]byte(`{"controlPlane":{"configVersion":2}}`)))
+
+	LoadLastGoodConfig()
+	assert.Equal(t, `{"controlPlane":{"configVersion":2}}`, string(config.Raw()))
+}
+
+func TestPinAndUnpin(t *testing.T) {
+	defer withLastGoodDir(t, 3)()
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+
+	loadRules()
+	cp := New()
+	origRules := len(cp.Rules())
+
+	gens, err := LastGoodConfigs()
+	assert.NoError(t, err)
+	if !assert.Len(t, gens, 1) {
+		return
+	}
+	pinTo := gens[0].Id
+
+	newConfig := func(version string) {
+		config.Load(bytes.NewBufferString(`{"controlPlane":{"configVersion":` + version + `,` +
+			`"regions":{"eu-west-1":{"id":"eu-west-1"}},` +
+			`"rules":{"a":{"action":2,"match":{"path":"/v1/point","proportion":1}}}}}`))
+		cp.loadCycle()
+	}
+
+	newConfig("2")
+	assert.Len(t, cp.Rules(), 1)
+
+	// pinning takes us back to the original config
+	assert.NoError(t, cp.Pin(pinTo))
+	assert.Equal(t, pinTo, cp.Pinned())
+	assert.Len(t, cp.Rules(), origRules)
+	assert.Equal(t, pinTo, cp.History()[0].Pinned)
+
+	// and keeps us there, even when the config service changes
+	newConfig("3")
+	assert.Len(t, cp.Rules(), origRules)
+
+	// until we unpin
+	assert.NoError(t, cp.Unpin())
+	assert.Equal(t, "", cp.Pinned())
+	assert.Len(t, cp.Rules(), 1)
+	assert.Equal(t, int64(3), cp.loadedConfig().rConfigVersion)
+
+	assert.Error(t, cp.Pin("20060102T150405.000000000Z"), "Expecting an error pinning to a missing generation")
+	assert.Equal(t, "", cp.Pinned())
+}
+
+func TestPinDuringReloadLoop(t *testing.T) {
+	defer withLastGoodDir(t, 3)()
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+
+	loadRules()
+	cp := New()
+	gens, err := LastGoodConfigs()
+	assert.NoError(t, err)
+	if !assert.Len(t, gens, 1) {
+		return
+	}
+
+	// a reload loop retrying bad config holds the loop lock; pinning shouldn't wait for it
+	cp.loadCycleLock.Lock()
+	defer cp.loadCycleLock.Unlock()
+
+	pinned := make(chan error, 1)
+	go func() {
+		pinned <- cp.Pin(gens[0].Id)
+	}()
+	select {
+	case err := <-pinned:
+		assert.NoError(t, err)
+		assert.Equal(t, gens[0].Id, cp.Pinned())
+	case <-time.After(time.Second):
+		t.Fatal("Pin blocked on the reload loop")
+	}
+
+	// and once pinned, reloads don't touch the config service
+	config.Load(bytes.NewBufferString(`{"controlPlane":{"rules":{"a":{"action":99}}}}`))
+	assert.NoError(t, cp.tryLoad())
+}
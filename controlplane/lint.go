@@ -0,0 +1,497 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Severity of a problem found when linting config
+type Severity string
+
+const (
+	// SeverityError problems stop config from loading, or stop it from working as intended
+	SeverityError Severity = "error"
+	// SeverityWarning problems are suspicious, but don't stop config from loading
+	SeverityWarning Severity = "warning"
+
+	lintRoot = "$.controlPlane"
+)
+
+// A Problem is something wrong with control plane config, found by Lint
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path"` // JSON path of the offending value, like $.controlPlane.rules.foo.action
+	Message  string   `json:"message"`
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Severity, p.Path, p.Message)
+}
+
+// Problems is a list of problems found when linting config
+type Problems []*Problem
+
+// HasErrors tells us if any of the problems are errors (rather than just warnings)
+func (ps Problems) HasErrors() bool {
+	for _, p := range ps {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps *Problems) add(sev Severity, path, format string, args ...interface{}) {
+	*ps = append(*ps, &Problem{
+		Severity: sev,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Lint checks raw config (as would be loaded from the config service) without loading it, returning any structural
+// problems (values of the wrong type, unknown fields), anything that would stop it loading, and problems that
+// wouldn't but are still wrong or suspicious (such as unknown actions, unreachable rules, or regions that don't exist)
+func Lint(rawConfig []byte) Problems {
+	problems := Problems{}
+
+	var generic map[string]interface{}
+	if err := json.Unmarshal(rawConfig, &generic); err != nil {
+		problems.add(SeverityError, "$", "invalid JSON: %v", err)
+		return problems
+	}
+	cp, ok := generic["controlPlane"]
+	if !ok {
+		problems.add(SeverityError, lintRoot, "missing")
+		return problems
+	}
+
+	// only carry on to semantic checks if the structure is sound, since otherwise we can't parse it
+	lintStructure(&problems, lintRoot, cp, reflect.TypeOf(parsedControlPlane{}))
+	if problems.HasErrors() {
+		return problems
+	}
+
+	// then whether it would load, exactly as loading does, before the checks loading doesn't make (which rely on
+	// the config having been compiled)
+	parsed, err := parseConfig(rawConfig)
+	if err != nil {
+		problems.add(SeverityError, "$", "%v", err)
+		return problems
+	}
+	cfg, err := compileConfig(parsed)
+	if err != nil {
+		problems.add(SeverityError, lintRoot, "won't load: %v", err)
+		return problems
+	}
+
+	lintRules(&problems, parsed.Cp.Rules, cfg.rules)
+	lintRegions(&problems, parsed.Cp.Regions, parsed.Cp.HobRegions)
+	lintHobGroups(&problems, parsed.Cp.HobGroups, parsed.Cp.Rules)
+	lintH2Routes(&problems, parsed.Cp.H2Routes)
+
+	return problems
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// lintStructure walks a generic JSON value alongside the type it will be unmarshaled into, reporting mismatches
+func lintStructure(problems *Problems, path string, v interface{}, t reflect.Type) {
+	if v == nil {
+		return // null is fine for anything
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// types that unmarshal themselves (eg: times) are best tested by doing just that
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		b, _ := json.Marshal(v)
+		if err := json.Unmarshal(b, reflect.New(t).Interface()); err != nil {
+			problems.add(SeverityError, path, "%v", err)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			problems.add(SeverityError, path, "expected an object, got %s", jsonKind(v))
+			return
+		}
+		for _, k := range sortedKeys(obj) {
+			f, ok := jsonField(t, k)
+			if !ok {
+				problems.add(SeverityWarning, lintPath(path, k), "unknown field")
+				continue
+			}
+			lintStructure(problems, lintPath(path, k), obj[k], f.Type)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			problems.add(SeverityError, path, "expected an object, got %s", jsonKind(v))
+			return
+		}
+		for _, k := range sortedKeys(obj) {
+			lintStructure(problems, lintPath(path, k), obj[k], t.Elem())
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			problems.add(SeverityError, path, "expected an array, got %s", jsonKind(v))
+			return
+		}
+		for i, e := range arr {
+			lintStructure(problems, fmt.Sprintf("%s[%d]", path, i), e, t.Elem())
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			problems.add(SeverityError, path, "expected a string, got %s", jsonKind(v))
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			problems.add(SeverityError, path, "expected a boolean, got %s", jsonKind(v))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := v.(float64); !ok {
+			problems.add(SeverityError, path, "expected an integer, got %s", jsonKind(v))
+		} else if n != math.Trunc(n) {
+			problems.add(SeverityError, path, "expected an integer, got %v", n)
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := v.(float64); !ok {
+			problems.add(SeverityError, path, "expected a number, got %s", jsonKind(v))
+		}
+	}
+}
+
+// jsonField finds the struct field a JSON key unmarshals into (matching case-insensitively, as encoding/json does)
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	default:
+		return "null"
+	}
+}
+
+func lintPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]'") {
+		return fmt.Sprintf("%s['%s']", path, key)
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lintRules checks each (compiled) rule in isolation, and then looks for rules that can never be reached because a
+// rule evaluated before them always matches first
+func lintRules(problems *Problems, rules Rules, sorted SortedRules) {
+	paths := make(map[*Rule]string, len(rules))
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rule := rules[k]
+		path := lintPath(lintRoot+".rules", k)
+		paths[rule] = path
+		if rule == nil {
+			problems.add(SeverityError, path, "rule is null")
+			continue
+		}
+
+		if rule.Action.String() == "?" {
+			problems.add(SeverityError, path+".action", "unknown action %d", rule.Action)
+		}
+		if rule.Mock != nil && rule.Mock.Err() != nil {
+			problems.add(SeverityError, path+".mock.bodyFile", "%v", rule.Mock.Err())
+		}
+		if rule.Match == nil {
+			problems.add(SeverityWarning, path+".match", "missing, so this rule will never match")
+			continue
+		}
+		if rule.Action == ActionUpgrade && rule.Match.AppVersion == nil {
+			problems.add(SeverityWarning, path+".match.appVersion", "missing, so every client matched will be told to upgrade")
+		}
+		if p := rule.Match.Proportion; p < 0 || p > 1 {
+			problems.add(SeverityError, path+".match.proportion", "%v is outside 0-1", p)
+		}
+		switch rule.Match.Sampler {
+		case RandomSampler:
+			if rule.Match.Proportion <= 0 {
+				problems.add(SeverityWarning, path+".match.proportion", "0 (or missing), so this rule will never match")
+			}
+		case CustomerSampler, DriverSampler, DeviceSampler, SessionSampler, KeyedSampler:
+		default:
+			problems.add(SeverityError, path+".match.sampler", "unknown sampler %d", rule.Match.Sampler)
+		}
+	}
+
+	for j, later := range sorted {
+		if later == nil {
+			continue
+		}
+		for _, earlier := range sorted[:j] {
+			if earlier == nil || !earlier.alwaysMatchesBefore(later) {
+				continue
+			}
+			if earlier.Specificity() == later.Specificity() {
+				problems.add(SeverityWarning, paths[later], "may be unreachable: %s matches everything this does "+
+					"with the same specificity, so which is evaluated first is arbitrary", paths[earlier])
+			} else {
+				problems.add(SeverityWarning, paths[later], "unreachable: shadowed by %s, which always matches first",
+					paths[earlier])
+			}
+			break
+		}
+	}
+}
+
+// alwaysMatchesBefore tells us if this rule, when evaluated before another, will always match any request the other
+// would. We err on the side of false, since this is only used to warn about unreachable rules
+func (r *Rule) alwaysMatchesBefore(other *Rule) bool {
+	if r.Shadow || r.ActiveFrom != nil || r.ActiveUntil != nil || len(r.Windows) > 0 {
+		return false
+	}
+	if r.Match == nil || other.Match == nil || r.Match.Proportion < 1 || r.Match.Blank == BlankNoMatch {
+		return false
+	}
+	m, o := r.Match, other.Match
+
+	if len(m.Path) > 0 && !strings.HasPrefix(o.Path, m.Path) {
+		return false
+	}
+	if (len(m.PathTemplate) > 0 && m.PathTemplate != o.PathTemplate) || (len(m.PathRegex) > 0 && m.PathRegex != o.PathRegex) {
+		return false
+	}
+	if len(m.Source) > 0 && m.Source != o.Source {
+		return false
+	}
+	if len(m.IPs) > 0 && strings.Join(m.IPs, ",") != strings.Join(o.IPs, ",") {
+		return false
+	}
+	if len(m.Expr) > 0 && m.Expr != o.Expr {
+		return false
+	}
+	if m.AppVersion != nil && !m.AppVersion.sameAs(o.AppVersion) {
+		return false
+	}
+	if len(m.Method) > 0 && !csvSubset(strings.ToUpper(o.Method), strings.ToUpper(m.Method)) {
+		return false
+	}
+	if len(m.Hob) > 0 && !hobSubset(o, m) {
+		return false
+	}
+	for name, vm := range m.Headers {
+		if ovm, ok := o.Headers[name]; !ok || !vm.sameAs(ovm) {
+			return false
+		}
+	}
+	for name, vm := range m.Params {
+		if ovm, ok := o.Params[name]; !ok || !vm.sameAs(ovm) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameAs tells us if two value matches have the same criteria
+func (vm *ValueMatch) sameAs(other *ValueMatch) bool {
+	if vm == nil || other == nil {
+		return vm == other
+	}
+	return vm.Equals == other.Equals && vm.Prefix == other.Prefix && vm.Regex == other.Regex
+}
+
+// hobSubset tells us if every HOB in the (non-empty) regulatory area of sub is also within that of super, with HOB
+// groups expanded if they've been compiled
+func hobSubset(sub, super *Match) bool {
+	if sub.hobs == nil || super.hobs == nil {
+		return csvSubset(sub.Hob, super.Hob)
+	}
+	if len(sub.hobs) == 0 {
+		return false
+	}
+	for hob := range sub.hobs {
+		if !super.hobs[hob] {
+			return false
+		}
+	}
+	return true
+}
+
+// csvSubset tells us if every value in the (non-empty) CSV sub is also within the CSV super
+func csvSubset(sub, super string) bool {
+	if len(sub) == 0 {
+		return false
+	}
+	for _, v := range strings.Split(sub, ",") {
+		if !withinCsv(super, strings.TrimSpace(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// lintRegions checks that every region referred to (by failovers and HOB regions) exists, and looks for failover
+// cycles
+func lintRegions(problems *Problems, regions Regions, hobRegions HobRegions) {
+	ids := make([]string, 0, len(regions))
+	for id := range regions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		region := regions[id]
+		path := lintPath(lintRoot+".regions", id)
+		if region == nil {
+			problems.add(SeverityError, path, "region is null")
+			continue
+		}
+		if region.Id != id {
+			problems.add(SeverityWarning, path+".id", "%q doesn't match the region's key %q", region.Id, id)
+		}
+		for i, foId := range region.Failover {
+			if regions[foId] == nil {
+				problems.add(SeverityError, fmt.Sprintf("%s.failover[%d]", path, i), "region %q doesn't exist", foId)
+			}
+		}
+	}
+
+	for _, cycle := range failoverCycles(regions, ids) {
+		problems.add(SeverityWarning, lintPath(lintRoot+".regions", cycle[0])+".failover", "failover cycle: %s",
+			strings.Join(cycle, " -> "))
+	}
+
+	hobs := make([]string, 0, len(hobRegions))
+	for hob := range hobRegions {
+		hobs = append(hobs, hob)
+	}
+	sort.Strings(hobs)
+	for _, hob := range hobs {
+		if regions[hobRegions[hob]] == nil {
+			problems.add(SeverityError, lintPath(lintRoot+".hobRegions", hob), "region %q doesn't exist", hobRegions[hob])
+		}
+	}
+}
+
+// lintHobGroups warns about any HOB groups that no rule refers to
+func lintHobGroups(problems *Problems, hobGroups HobGroups, rules Rules) {
+	used := make(map[string]bool)
+	for _, rule := range rules {
+		if rule == nil || rule.Match == nil {
+			continue
+		}
+		for _, v := range strings.Split(rule.Match.Hob, ",") {
+			if v = strings.TrimSpace(v); strings.HasPrefix(v, hobGroupPrefix) {
+				used[v[len(hobGroupPrefix):]] = true
+			}
+		}
+	}
+	for _, name := range hobGroups.names() {
+		if !used[name] {
+			problems.add(SeverityWarning, lintPath(lintRoot+".hobGroups", name), "no rule refers to @%s", name)
+		}
+	}
+}
+
+// failoverCycles finds every distinct cycle of failovers between regions, each starting (and ending) with its
+// lexicographically first region
+func failoverCycles(regions Regions, ids []string) [][]string {
+	var cycles [][]string
+	seen := make(map[string]bool)
+
+	var walk func(start string, path []string)
+	walk = func(start string, path []string) {
+		current := regions[path[len(path)-1]]
+		if current == nil {
+			return
+		}
+		for _, next := range current.Failover {
+			switch {
+			case next == start:
+				cycle := append(append([]string{}, path...), start)
+				if key := strings.Join(cycle, ","); !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+			case next > start && !containsString(path, next):
+				// only walk regions after the start, so we find each cycle once (from its first region)
+				walk(start, append(path, next))
+			}
+		}
+	}
+
+	for _, id := range ids {
+		walk(id, []string{id})
+	}
+	return cycles
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// lintH2Routes looks for (compiled) H2 routes that can never be reached because an earlier one always matches first
+func lintH2Routes(problems *Problems, routes H2Routes) {
+	for i, r := range routes {
+		path := fmt.Sprintf("%s.h2Routes[%d]", lintRoot, i)
+		for j, earlier := range routes[:i] {
+			if earlier != nil && earlier.pathTemplate != nil && earlier.pathTemplate.covers(r.pathTemplate) {
+				problems.add(SeverityWarning, path, "unreachable: shadowed by %s.h2Routes[%d], which always matches first",
+					lintRoot, j)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// problemAt finds the first problem at a path
+func problemAt(problems Problems, path string) *Problem {
+	for _, p := range problems {
+		if p.Path == path {
+			return p
+		}
+	}
+	return nil
+}
+
+const lintRegionsJson = `"regions":{"eu-west-1":{"id":"eu-west-1","failover":["us-east-1"]},"us-east-1":{"id":"us-east-1"}}`
+
+func TestLintValid(t *testing.T) {
+	problems := Lint([]byte(`{"controlPlane":{
+		"rules":{
+			"a":{"action":1,"match":{"path":"/v1/order","proportion":1}},
+			"b":{"action":2,"match":{"path":"/v1/order","source":"customer","proportion":0.5}}
+		},
+		"hobRegions":{"LON":"eu-west-1"},
+		` + lintRegionsJson + `}}`))
+	assert.Empty(t, problems)
+	assert.False(t, problems.HasErrors())
+}
+
+func TestLintStructure(t *testing.T) {
+	problems := Lint([]byte(`{"controlPlane":{
+		"rules":{
+			"a":{"action":"H1","match":{"path":"/v1/order","proportion":1,"colour":"red"},"activeFrom":"yesterday"},
+			"b":{"action":1.5,"match":{"path":["/v1"]}}
+		},
+		` + lintRegionsJson + `}}`))
+	assert.True(t, problems.HasErrors())
+
+	cases := []struct {
+		path     string
+		severity Severity
+	}{
+		{"$.controlPlane.rules.a.action", SeverityError},
+		{"$.controlPlane.rules.a.match.colour", SeverityWarning},
+		{"$.controlPlane.rules.a.activeFrom", SeverityError},
+		{"$.controlPlane.rules.b.action", SeverityError},
+		{"$.controlPlane.rules.b.match.path", SeverityError},
+	}
+	for _, tc := range cases {
+		p := problemAt(problems, tc.path)
+		if assert.NotNil(t, p, "Expecting a problem at %s; got %v", tc.path, problems) {
+			assert.Equal(t, tc.severity, p.Severity, "Wrong severity for %s", tc.path)
+		}
+	}
+
+	// arrays of rules (rather than objects) are the classic mistake
+	problems = Lint([]byte(`{"controlPlane":{"rules":[{"action":1}]}}`))
+	p := problemAt(problems, "$.controlPlane.rules")
+	if assert.NotNil(t, p) {
+		assert.Equal(t, "expected an object, got an array", p.Message)
+	}
+
+	problems = Lint([]byte(`{"api":{}}`))
+	assert.NotNil(t, problemAt(problems, "$.controlPlane"))
+}
+
+func TestLintRules(t *testing.T) {
+	problems := Lint([]byte(`{"controlPlane":{
+		"rules":{
+			"unknownAction":{"action":99,"match":{"path":"/v1/a","proportion":1}},
+			"tooMuch":{"action":1,"match":{"path":"/v1/b","proportion":1.5}},
+			"never":{"action":1,"match":{"path":"/v1/c"}},
+			"badSampler":{"action":1,"match":{"path":"/v1/d","proportion":1,"sampler":7}},
+			"missingMock":{"action":8,"match":{"path":"/v1/e","proportion":1},"mock":{"bodyFile":"missing.json"}}
+		},
+		` + lintRegionsJson + `}}`))
+
+	cases := []struct {
+		path     string
+		severity Severity
+	}{
+		{"$.controlPlane.rules.unknownAction.action", SeverityError},
+		{"$.controlPlane.rules.tooMuch.match.proportion", SeverityError},
+		{"$.controlPlane.rules.never.match.proportion", SeverityWarning},
+		{"$.controlPlane.rules.badSampler.match.sampler", SeverityError},
+		{"$.controlPlane.rules.missingMock.mock.bodyFile", SeverityError},
+	}
+	for _, tc := range cases {
+		p := problemAt(problems, tc.path)
+		if assert.NotNil(t, p, "Expecting a problem at %s; got %v", tc.path, problems) {
+			assert.Equal(t, tc.severity, p.Severity, "Wrong severity for %s", tc.path)
+		}
+	}
+}
+
+func TestLintWontLoad(t *testing.T) {
+	cases := []string{
+		`"rules":{"badRegex":{"action":1,"match":{"pathRegex":"(","proportion":1}}},` + lintRegionsJson,
+		`"rules":{"a":{"action":1,"match":{"path":"/v1/order","proportion":1}}},"regions":{}`,
+		`"rules":{"a":{"action":1,"match":{"regulatoryArea":"@missing","proportion":1}}},` + lintRegionsJson,
+		`"rules":{"a":{"action":1,"match":{"path":"/v1/order","proportion":1}}},"hobTimezones":{"LON":"Europe/Nowhere"},` +
+			lintRegionsJson,
+	}
+	for i, tc := range cases {
+		raw := []byte(`{"controlPlane":{` + tc + `}}`)
+		cp := &ControlPlane{}
+		loadErr := cp.load(raw, "pinned")
+		assert.Error(t, loadErr, "Case %d", i)
+
+		// whatever stops config loading is an error when linting, with the same message
+		problems := Lint(raw)
+		p := problemAt(problems, "$.controlPlane")
+		if assert.NotNil(t, p, "Case %d: expecting a problem; got %v", i, problems) {
+			assert.Equal(t, SeverityError, p.Severity, "Case %d", i)
+			assert.Contains(t, p.Message, loadErr.Error(), "Case %d", i)
+		}
+	}
+}
+
+func TestLintUnreachableRules(t *testing.T) {
+	problems := Lint([]byte(`{"controlPlane":{
+		"rules":{
+			"lonDrivers":{"action":1,"match":{"path":"/v1/driver","source":"driver","regulatoryArea":"LON,DUB","proportion":1}},
+			"lonDriverJobs":{"action":2,"match":{"path":"/v1/driver/jobs","method":"GET","source":"driver","regulatoryArea":"LON","proportion":1}},
+			"sampled":{"action":1,"match":{"pathTemplate":"/v1/customer/{id}","regulatoryArea":"LON,DUB","proportion":0.5}},
+			"sampledLon":{"action":2,"match":{"pathTemplate":"/v1/customer/{id}","regulatoryArea":"LON","proportion":1}},
+			"scheduled":{"action":1,"match":{"pathTemplate":"/v1/point","regulatoryArea":"LON,DUB","proportion":1},"windows":[{"start":"02:00","end":"04:00"}]},
+			"scheduledLon":{"action":2,"match":{"pathTemplate":"/v1/point","regulatoryArea":"LON","proportion":1}}
+		},
+		` + lintRegionsJson + `}}`))
+
+	// the more specific rules come first, and the general ones don't always match, so nothing is shadowed
+	for _, id := range []string{"lonDrivers", "lonDriverJobs", "sampled", "sampledLon", "scheduled", "scheduledLon"} {
+		assert.Nil(t, problemAt(problems, "$.controlPlane.rules."+id), "Unexpected problem with %s", id)
+	}
+
+	// identical rules shadow one another, depending on the order we happen to sort them in
+	problems = Lint([]byte(`{"controlPlane":{
+		"rules":{
+			"a":{"action":1,"match":{"path":"/v1/driver","proportion":1}},
+			"b":{"action":2,"match":{"path":"/v1/driver","proportion":1}}
+		},
+		` + lintRegionsJson + `}}`))
+	pa, pb := problemAt(problems, "$.controlPlane.rules.a"), problemAt(problems, "$.controlPlane.rules.b")
+	assert.True(t, (pa == nil) != (pb == nil), "Expecting exactly one of a, b to be unreachable; got %v", problems)
+	for _, p := range []*Problem{pa, pb} {
+		if p != nil {
+			assert.Contains(t, p.Message, "may be unreachable")
+		}
+	}
+}
+
+func TestAlwaysMatchesBefore(t *testing.T) {
+	general := &Rule{Match: &Match{Path: "/v1", Hob: "LON,DUB", Method: "GET,POST", Proportion: 1}}
+	specific := &Rule{Match: &Match{Path: "/v1/order", Hob: "LON", Method: "GET", Source: "customer", Proportion: 0.5}}
+	assert.True(t, general.alwaysMatchesBefore(specific))
+	assert.False(t, specific.alwaysMatchesBefore(general))
+
+	// anything not in the general rule's CSVs means it might not match
+	specific.Match.Hob = "LON,MAD"
+	assert.False(t, general.alwaysMatchesBefore(specific))
+	specific.Match.Hob = ""
+	assert.False(t, general.alwaysMatchesBefore(specific))
+	specific.Match.Hob = "LON"
+
+	// as do headers it doesn't have
+	general.Match.Headers = map[string]*ValueMatch{"X-H-Foo": {Prefix: "bar"}}
+	assert.False(t, general.alwaysMatchesBefore(specific))
+	specific.Match.Headers = map[string]*ValueMatch{"X-H-Foo": {Prefix: "bar"}}
+	assert.True(t, general.alwaysMatchesBefore(specific))
+
+	// and shadow rules never match first
+	general.Shadow = true
+	assert.False(t, general.alwaysMatchesBefore(specific))
+}
+
+func TestLintRegions(t *testing.T) {
+	problems := Lint([]byte(`{"controlPlane":{
+		"rules":{"a":{"action":1,"match":{"path":"/v1/order","proportion":1}}},
+		"hobRegions":{"LON":"eu-west-1","NYC":"us-west-9"},
+		"regions":{
+			"eu-west-1":{"id":"eu-west-1","failover":["us-east-1","ap-south-1"]},
+			"us-east-1":{"id":"us-east-1","failover":["eu-west-1"]},
+			"ap-northeast-1":{"id":"ap-northeast-2"}
+		}}}`))
+
+	p := problemAt(problems, "$.controlPlane.regions.eu-west-1.failover[1]")
+	if assert.NotNil(t, p, "Expecting missing failover region; got %v", problems) {
+		assert.Equal(t, SeverityError, p.Severity)
+	}
+	p = problemAt(problems, "$.controlPlane.regions.eu-west-1.failover")
+	if assert.NotNil(t, p, "Expecting failover cycle; got %v", problems) {
+		assert.Equal(t, "failover cycle: eu-west-1 -> us-east-1 -> eu-west-1", p.Message)
+	}
+	p = problemAt(problems, "$.controlPlane.hobRegions.NYC")
+	if assert.NotNil(t, p, "Expecting missing HOB region; got %v", problems) {
+		assert.Equal(t, SeverityError, p.Severity)
+	}
+	assert.NotNil(t, problemAt(problems, "$.controlPlane.regions.ap-northeast-1.id"))
+	assert.Nil(t, problemAt(problems, "$.controlPlane.hobRegions.LON"))
+}
+
+func TestFailoverCycles(t *testing.T) {
+	regions := Regions{
+		"a": {Failover: []string{"b", "c"}},
+		"b": {Failover: []string{"c"}},
+		"c": {Failover: []string{"a"}},
+		"d": {Failover: []string{"d"}},
+	}
+	cycles := failoverCycles(regions, []string{"a", "b", "c", "d"})
+	assert.Equal(t, [][]string{
+		{"a", "b", "c", "a"},
+		{"a", "c", "a"},
+		{"d", "d"},
+	}, cycles)
+}
@@ -0,0 +1,32 @@
+package controlplane
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// compileMirror validates the mirror of a rule, if it has one
+func (r *Rule) compileMirror() error {
+	m := r.Mirror
+	if m == nil {
+		return nil
+	}
+	if r.Action != ActionProxyToH1 {
+		return fmt.Errorf("Mirror given for a %v rule; must be H1", r.Action)
+	}
+	if m.Proportion <= 0 || m.Proportion > 1 {
+		return fmt.Errorf("Mirror proportion %v must be more than 0, and at most 1", m.Proportion)
+	}
+	for _, field := range m.Ignore {
+		if len(field) == 0 || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return fmt.Errorf("Invalid mirror ignore field %q; must be a dotted path like payload.eta", field)
+		}
+	}
+	return nil
+}
+
+// Sample decides whether to mirror a request
+func (m *Mirror) Sample() bool {
+	return rand.Float32() < m.Proportion
+}
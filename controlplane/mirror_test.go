@@ -0,0 +1,33 @@
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorValidation(t *testing.T) {
+	cases := []struct {
+		rule  *Rule
+		valid bool
+	}{
+		{&Rule{Action: ActionProxyToH1, Mirror: &Mirror{Proportion: 1}}, true},
+		{&Rule{Action: ActionProxyToH1, Mirror: &Mirror{Proportion: 0.1, Ignore: []string{"payload.eta", "timestamp"}}}, true},
+
+		{&Rule{Action: ActionProxyToH1, Mirror: &Mirror{}}, false},
+		{&Rule{Action: ActionProxyToH1, Mirror: &Mirror{Proportion: 1.5}}, false},
+		{&Rule{Action: ActionProxyToH1, Mirror: &Mirror{Proportion: 1, Ignore: []string{"payload..eta"}}}, false},
+		{&Rule{Action: ActionProxyToH1, Mirror: &Mirror{Proportion: 1, Ignore: []string{""}}}, false},
+		// we only mirror requests served by H1
+		{&Rule{Action: ActionSendToH2, Mirror: &Mirror{Proportion: 1}}, false},
+	}
+
+	for i, tc := range cases {
+		err := tc.rule.Compile()
+		if tc.valid {
+			assert.NoError(t, err, "Case %d", i)
+		} else {
+			assert.Error(t, err, "Case %d", i)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	defaultMockContentType = "application/json; charset=utf-8"
+	// maxMockLatency keeps artificial latency well inside the server's write timeout
+	maxMockLatency = 20 * time.Second
+)
+
+var (
+	// MockDir is the directory mock body files are relative to
+	MockDir = "/opt/hailo/etc/api-proxy-mocks"
+
+	// mockPlaceholder matches placeholders like {{hob}} in mock bodies and headers (JSON never has {{)
+	mockPlaceholder = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+	// mockPlaceholders are those we know how to fill in
+	mockPlaceholders = map[string]bool{
+		"hob":       true,
+		"path":      true,
+		"requestId": true,
+	}
+)
+
+// MockVars are the values from a request we can fill in to mock responses
+type MockVars struct {
+	Hob       string
+	Path      string
+	RequestId string
+}
+
+// compileMock validates the mock of a rule, if it has one (and checks it does, if it's a mock rule), reading its body
+// file and parsing its latency. A body file we can't read doesn't fail the config, just the rule, which serves an
+// error instead (see Err)
+func (r *Rule) compileMock() error {
+	if r.Action != ActionMock {
+		if r.Mock != nil {
+			return fmt.Errorf("Mock given for a %v rule", r.Action)
+		}
+		return nil
+	}
+
+	m := r.Mock
+	if m == nil {
+		return fmt.Errorf("Mock rules must have a mock")
+	}
+	if len(m.Body) > 0 && len(m.BodyFile) > 0 {
+		return fmt.Errorf("Mock must have a body or a body file, not both")
+	}
+	if m.HttpStatus != 0 && (m.HttpStatus < 100 || m.HttpStatus > 599) {
+		return fmt.Errorf("Invalid mock status %d", m.HttpStatus)
+	}
+
+	m.body, m.bodyErr = m.Body, nil
+	if len(m.BodyFile) > 0 {
+		fn, err := mockBodyPath(m.BodyFile)
+		if err != nil {
+			return err
+		}
+		if b, err := ioutil.ReadFile(fn); err != nil {
+			m.bodyErr = fmt.Errorf("Failed to read mock body file: %v", err)
+			log.Errorf("[Control Plane] Rule %s: %v", r.Id(), m.bodyErr)
+		} else {
+			m.body = string(b)
+		}
+	}
+	m.json = strings.Contains(strings.ToLower(mockContentType(m.Headers)), "json")
+
+	m.latency = 0
+	if len(m.Latency) > 0 {
+		d, err := time.ParseDuration(m.Latency)
+		if err != nil {
+			return fmt.Errorf("Invalid mock latency: %v", err)
+		}
+		if d < 0 || d > maxMockLatency {
+			return fmt.Errorf("Mock latency %v must be between 0 and %v", d, maxMockLatency)
+		}
+		m.latency = d
+	}
+
+	if err := checkMockPlaceholders(m.body); err != nil {
+		return err
+	}
+	for name, v := range m.Headers {
+		if err := checkMockPlaceholders(v); err != nil {
+			return fmt.Errorf("Header %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// mockBodyPath finds a body file within MockDir, refusing any (absolute, or climbing out with ..) elsewhere
+func mockBodyPath(fn string) (string, error) {
+	if filepath.IsAbs(fn) {
+		return "", fmt.Errorf("Mock body file %s must be relative to the mock directory", fn)
+	}
+	clean := filepath.Clean(fn)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Mock body file %s must be within the mock directory", fn)
+	}
+	return filepath.Join(MockDir, clean), nil
+}
+
+// mockContentType is the content type given in mock headers, or the default
+func mockContentType(headers map[string]string) string {
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			return v
+		}
+	}
+	return defaultMockContentType
+}
+
+func checkMockPlaceholders(s string) error {
+	for _, m := range mockPlaceholder.FindAllStringSubmatch(s, -1) {
+		if !mockPlaceholders[strings.TrimSpace(m[1])] {
+			return fmt.Errorf("Unknown mock placeholder %s; must be one of {{hob}}, {{path}} or {{requestId}}", m[0])
+		}
+	}
+	return nil
+}
+
+// StatusCode returns the HTTP status to respond with, defaulting to 200
+func (m *Mock) StatusCode() int {
+	if m.HttpStatus == 0 {
+		return http.StatusOK
+	}
+	return m.HttpStatus
+}
+
+// Err is why the mock can't be served (its body file couldn't be read when config was loaded), if it can't
+func (m *Mock) Err() error {
+	return m.bodyErr
+}
+
+// Delay returns how long to wait before responding
+func (m *Mock) Delay() time.Duration {
+	return m.latency
+}
+
+// RenderBody returns the body to respond with, with placeholders filled in (and escaped to go in JSON strings, if
+// the body is JSON)
+func (m *Mock) RenderBody(vars MockVars) string {
+	if m.json {
+		return vars.expand(m.body, jsonEscape)
+	}
+	return vars.expand(m.body, nil)
+}
+
+// RenderHeaders returns the headers to respond with, with placeholders filled in, including a JSON content type unless
+// another is given
+func (m *Mock) RenderHeaders(vars MockVars) http.Header {
+	h := make(http.Header, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		h.Set(k, vars.expand(v, nil))
+	}
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", defaultMockContentType)
+	}
+	return h
+}
+
+// expand fills in placeholders, escaping the values with escape, if given
+func (vars MockVars) expand(s string, escape func(string) string) string {
+	return mockPlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+		var v string
+		switch strings.TrimSpace(m[2 : len(m)-2]) {
+		case "hob":
+			v = vars.Hob
+		case "path":
+			v = vars.Path
+		case "requestId":
+			v = vars.RequestId
+		default:
+			return m
+		}
+		if escape != nil {
+			v = escape(v)
+		}
+		return v
+	})
+}
+
+// jsonEscape escapes a value to go within a JSON string
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
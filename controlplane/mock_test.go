@@ -0,0 +1,110 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMockValidation(t *testing.T) {
+	cases := []struct {
+		rule  *Rule
+		valid bool
+	}{
+		{&Rule{Action: ActionMock, Mock: &Mock{}}, true},
+		{&Rule{Action: ActionMock, Mock: &Mock{Body: `{"status":true,"payload":{"hob":"{{hob}}"}}`, HttpStatus: 201}}, true},
+		{&Rule{Action: ActionMock, Mock: &Mock{Latency: "200ms", Headers: map[string]string{"X-Request-Id": "{{ requestId }}"}}}, true},
+
+		{&Rule{Action: ActionMock}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{Body: "{}", BodyFile: "foo.json"}}, false},
+		// body files must be within MockDir
+		{&Rule{Action: ActionMock, Mock: &Mock{BodyFile: "/etc/passwd"}}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{BodyFile: "../secret.json"}}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{BodyFile: "foo/../../secret.json"}}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{HttpStatus: 1000}}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{Latency: "soon"}}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{Latency: "1h"}}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{Body: "{{customer}}"}}, false},
+		{&Rule{Action: ActionMock, Mock: &Mock{Headers: map[string]string{"X-Foo": "{{customer}}"}}}, false},
+		{&Rule{Action: ActionThrottle, Mock: &Mock{}}, false},
+	}
+
+	for i, tc := range cases {
+		err := tc.rule.Compile()
+		if tc.valid {
+			assert.NoError(t, err, "Case %d", i)
+		} else {
+			assert.Error(t, err, "Case %d", i)
+		}
+	}
+}
+
+func TestMockRender(t *testing.T) {
+	vars := MockVars{Hob: "LON", Path: "/v1/order/123", RequestId: "abc"}
+
+	rule := &Rule{Action: ActionMock, Mock: &Mock{
+		Body:    `{"status":true,"payload":{"hob":"{{hob}}","path":"{{path}}","id":"{{ requestId }}"}}`,
+		Headers: map[string]string{"X-Request-Id": "{{requestId}}"},
+		Latency: "50ms",
+	}}
+	assert.NoError(t, rule.Compile())
+	assert.Equal(t, `{"status":true,"payload":{"hob":"LON","path":"/v1/order/123","id":"abc"}}`, rule.Mock.RenderBody(vars))
+	h := rule.Mock.RenderHeaders(vars)
+	assert.Equal(t, "abc", h.Get("X-Request-Id"))
+	assert.Equal(t, "application/json; charset=utf-8", h.Get("Content-Type"))
+	assert.Equal(t, 200, rule.Mock.StatusCode())
+	assert.Equal(t, 50*time.Millisecond, rule.Mock.Delay())
+
+	// bodies can come from files, relative to MockDir
+	dir, err := ioutil.TempDir("", "api-proxy-mocks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	origDir := MockDir
+	MockDir = dir
+	defer func() { MockDir = origDir }()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "order.xml"), []byte("<order hob=\"{{hob}}\"/>"), 0644))
+
+	rule = &Rule{Action: ActionMock, Mock: &Mock{
+		BodyFile:   "order.xml",
+		HttpStatus: 503,
+		Headers:    map[string]string{"Content-Type": "application/xml"},
+	}}
+	assert.NoError(t, rule.Compile())
+	assert.Equal(t, `<order hob="LON"/>`, rule.Mock.RenderBody(vars))
+	assert.Equal(t, "application/xml", rule.Mock.RenderHeaders(vars).Get("Content-Type"))
+	assert.Equal(t, 503, rule.Mock.StatusCode())
+	assert.NoError(t, rule.Mock.Err())
+
+	// a missing file only breaks its rule
+	rule = &Rule{Action: ActionMock, Mock: &Mock{BodyFile: "foo/../missing.json"}}
+	assert.NoError(t, rule.Compile())
+	assert.Error(t, rule.Mock.Err())
+}
+
+func TestMockRenderEscapesJson(t *testing.T) {
+	vars := MockVars{Hob: "LON", Path: `/v1/"quoted"\`, RequestId: "</script>"}
+
+	rule := &Rule{Action: ActionMock, Mock: &Mock{
+		Body: `{"path":"{{path}}","id":"{{requestId}}"}`,
+	}}
+	assert.NoError(t, rule.Compile())
+	body := rule.Mock.RenderBody(vars)
+	v := map[string]string{}
+	if assert.NoError(t, json.Unmarshal([]byte(body), &v)) {
+		assert.Equal(t, vars.Path, v["path"])
+		assert.Equal(t, vars.RequestId, v["id"])
+	}
+
+	// but bodies that aren't JSON are left alone
+	rule = &Rule{Action: ActionMock, Mock: &Mock{
+		Body:    `path={{path}}`,
+		Headers: map[string]string{"content-type": "text/plain"},
+	}}
+	assert.NoError(t, rule.Compile())
+	assert.Equal(t, `path=/v1/"quoted"\`, rule.Mock.RenderBody(vars))
+}
@@ -0,0 +1,102 @@
+package controlplane
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// wildcardSegment matches any single path segment, without capturing it
+	wildcardSegment = "*"
+)
+
+// pathTemplate is a parsed path template, such as /v1/order/{id}/cancel. A template matches a whole path (not just
+// a prefix) where each {param} or * matches exactly one non-empty path segment
+type pathTemplate []templateSegment
+
+// templateSegment is a single segment of a path template; either a literal or a placeholder
+type templateSegment struct {
+	literal string // literal is the segment text, when this isn't a placeholder
+	param   string // param is the placeholder name, eg: "id" for {id}
+	wild    bool   // wild is true for placeholders (both named and *)
+}
+
+// parsePathTemplate parses a template of the form /v1/order/{id}/cancel, validating it as it goes
+func parsePathTemplate(tpl string) (pathTemplate, error) {
+	if !strings.HasPrefix(tpl, "/") {
+		return nil, fmt.Errorf("Path template %q must begin with /", tpl)
+	}
+
+	parts := splitPath(tpl)
+	result := make(pathTemplate, len(parts))
+	seen := make(map[string]bool, len(parts))
+	for i, part := range parts {
+		switch {
+		case part == wildcardSegment:
+			result[i] = templateSegment{wild: true}
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" || strings.ContainsAny(name, "{}*") {
+				return nil, fmt.Errorf("Path template %q has an invalid placeholder %q", tpl, part)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("Path template %q repeats placeholder %q", tpl, part)
+			}
+			seen[name] = true
+			result[i] = templateSegment{param: name, wild: true}
+		case strings.ContainsAny(part, "{}*"):
+			return nil, fmt.Errorf("Path template %q has a placeholder that isn't a whole segment: %q", tpl, part)
+		case part == "":
+			return nil, fmt.Errorf("Path template %q has an empty segment", tpl)
+		default:
+			result[i] = templateSegment{literal: part}
+		}
+	}
+
+	return result, nil
+}
+
+// isExact tells us if this template has no placeholders (and so matches exactly one path)
+func (t pathTemplate) isExact() bool {
+	for _, seg := range t {
+		if seg.wild {
+			return false
+		}
+	}
+	return true
+}
+
+// match tests if a path matches this template, returning any named placeholder values
+func (t pathTemplate) match(p string) (params map[string]string, ok bool) {
+	parts := splitPath(p)
+	if len(parts) != len(t) {
+		return nil, false
+	}
+
+	for i, seg := range t {
+		switch {
+		case !seg.wild:
+			if parts[i] != seg.literal {
+				return nil, false
+			}
+		case parts[i] == "":
+			return nil, false
+		case seg.param != "":
+			if params == nil {
+				params = make(map[string]string, len(t))
+			}
+			params[seg.param] = parts[i]
+		}
+	}
+
+	return params, true
+}
+
+// splitPath splits a path into its segments, ignoring the leading and any trailing slash
+func splitPath(p string) []string {
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "/"), "/")
+	if p == "" {
+		return []string{}
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,61 @@
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	valid := []string{
+		"/",
+		"/v1/order",
+		"/v1/order/{id}/cancel",
+		"/v1/order/*/cancel",
+		"/v1/{service}/{endpoint}",
+	}
+	for _, tpl := range valid {
+		_, err := parsePathTemplate(tpl)
+		assert.NoError(t, err, "Expecting %s to be a valid template", tpl)
+	}
+
+	invalid := []string{
+		"",
+		"v1/order",
+		"/v1/order/{}/cancel",
+		"/v1/order/{id}/{id}",
+		"/v1/order/id-{id}",
+		"/v1/order/**",
+		"/v1//order",
+	}
+	for _, tpl := range invalid {
+		_, err := parsePathTemplate(tpl)
+		assert.Error(t, err, "Expecting %s to be an invalid template", tpl)
+	}
+}
+
+func TestPathTemplateMatch(t *testing.T) {
+	testCases := []struct {
+		tpl, path string
+		matches   bool
+		params    map[string]string
+	}{
+		{"/v1/order/{id}/cancel", "/v1/order/123/cancel", true, map[string]string{"id": "123"}},
+		{"/v1/order/{id}/cancel", "/v1/order/123/cancel/", true, map[string]string{"id": "123"}},
+		{"/v1/order/{id}/cancel", "/v1/order/123", false, nil},
+		{"/v1/order/{id}/cancel", "/v1/order/123/cancel/now", false, nil},
+		{"/v1/order/{id}/cancel", "/v1/order//cancel", false, nil},
+		{"/v1/order/*/cancel", "/v1/order/123/cancel", true, nil},
+		{"/v1/order", "/v1/order", true, nil},
+		{"/v1/order", "/v1/orders", false, nil},
+		{"/", "/", true, nil},
+	}
+
+	for _, tc := range testCases {
+		tpl, err := parsePathTemplate(tc.tpl)
+		assert.NoError(t, err)
+		params, ok := tpl.match(tc.path)
+		assert.Equal(t, tc.matches, ok, "%s against %s", tc.tpl, tc.path)
+		assert.Equal(t, tc.params, params, "%s against %s", tc.tpl, tc.path)
+	}
+}
@@ -0,0 +1,81 @@
+package controlplane
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// redirectPlaceholder matches placeholders like {path} in redirect targets
+	redirectPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+	// redirectPlaceholders are those we know how to fill in
+	redirectPlaceholders = map[string]bool{
+		"path":  true,
+		"query": true,
+		"hob":   true,
+	}
+)
+
+// compileRedirect validates the redirect of a rule, if it has one (and checks it does, if it's a redirect rule)
+func (r *Rule) compileRedirect() error {
+	if r.Action != ActionRedirect {
+		if r.Redirect != nil {
+			return fmt.Errorf("Redirect given for a %v rule", r.Action)
+		}
+		return nil
+	}
+
+	rd := r.Redirect
+	if rd == nil || len(rd.Target) == 0 {
+		return fmt.Errorf("Redirect rules must have a target")
+	}
+	switch rd.Status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("Redirect status must be 301, 302, 307 or 308, not %d", rd.Status)
+	}
+	for _, m := range redirectPlaceholder.FindAllStringSubmatch(rd.Target, -1) {
+		if !redirectPlaceholders[m[1]] {
+			return fmt.Errorf("Unknown redirect placeholder %s; must be one of {path}, {query} or {hob}", m[0])
+		}
+	}
+	if _, err := url.Parse(rd.expand("/", "", "")); err != nil {
+		return fmt.Errorf("Invalid redirect target: %v", err)
+	}
+
+	return nil
+}
+
+// StatusCode returns the HTTP status to redirect with, defaulting to 302
+func (rd *Redirect) StatusCode() int {
+	if rd.Status == 0 {
+		return http.StatusFound
+	}
+	return rd.Status
+}
+
+// Location fills in the target's placeholders from a request, returning the URL to redirect it to. The path is
+// escaped as it was in the original request, the query is the raw query string (without the ?) and a trailing ? is
+// dropped if there's no query. There's deliberately no {host}: the Host header is the client's to choose, so using
+// it would let anyone bounce users to a site of their choice
+func (rd *Redirect) Location(path, query, hob string) string {
+	return strings.TrimSuffix(rd.expand(path, query, url.QueryEscape(hob)), "?")
+}
+
+func (rd *Redirect) expand(path, query, hob string) string {
+	return redirectPlaceholder.ReplaceAllStringFunc(rd.Target, func(m string) string {
+		switch m {
+		case "{path}":
+			return path
+		case "{query}":
+			return query
+		case "{hob}":
+			return hob
+		default:
+			return m
+		}
+	})
+}
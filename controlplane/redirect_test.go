@@ -0,0 +1,51 @@
+package controlplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectValidation(t *testing.T) {
+	cases := []struct {
+		rule  *Rule
+		valid bool
+	}{
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "https://api.example.com{path}"}}, true},
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "/v2{path}?{query}&city={hob}", Status: 301}}, true},
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "https://api.example.com/v2{path}", Status: 307}}, true},
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "https://api.example.com/", Status: 308}}, true},
+
+		// must have a target
+		{&Rule{Action: ActionRedirect}, false},
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{}}, false},
+		// with a redirect status
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "/", Status: 200}}, false},
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "/", Status: 304}}, false},
+		// and only placeholders we know
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "/{customer}"}}, false},
+		{&Rule{Action: ActionRedirect, Redirect: &Redirect{Target: "https://{host}/v2{path}"}}, false},
+		// redirects need a redirect action
+		{&Rule{Action: ActionThrottle, Redirect: &Redirect{Target: "/"}}, false},
+	}
+
+	for i, tc := range cases {
+		err := tc.rule.Compile()
+		if tc.valid {
+			assert.NoError(t, err, "Case %d", i)
+		} else {
+			assert.Error(t, err, "Case %d", i)
+		}
+	}
+}
+
+func TestRedirectLocation(t *testing.T) {
+	rd := &Redirect{Target: "https://api.example.com/v2{path}?{query}"}
+	assert.Equal(t, 302, rd.StatusCode())
+	assert.Equal(t, "https://api.example.com/v2/v1/point/foo%20bar?a=1&b=2", rd.Location("/v1/point/foo%20bar", "a=1&b=2", "LON"))
+	assert.Equal(t, "https://api.example.com/v2/v1/point", rd.Location("/v1/point", "", "LON"))
+
+	rd = &Redirect{Target: "https://new.example.com/{hob}/index", Status: 301}
+	assert.Equal(t, 301, rd.StatusCode())
+	assert.Equal(t, "https://new.example.com/NYC/index", rd.Location("/", "", "NYC"))
+}
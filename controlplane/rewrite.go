@@ -0,0 +1,94 @@
+package controlplane
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// OriginalPathHeader is added to rewritten requests, holding the path the client asked for
+	OriginalPathHeader = "X-H-Original-Path"
+)
+
+// compileRewrite validates the rewrite of a rule, if it has one, compiling any regex for use when rewriting
+func (r *Rule) compileRewrite() error {
+	rw := r.Rewrite
+	if rw == nil {
+		return nil
+	}
+	rw.regex = nil
+
+	switch r.Action {
+	case ActionProxyToH1, ActionSendToH2, ActionSplit, ActionProxyToUpstream:
+	default:
+		return fmt.Errorf("Rewrite given for a %v rule; must be H1, H2, split or upstream", r.Action)
+	}
+
+	if len(rw.Prefix) > 0 && len(rw.Regex) > 0 {
+		return fmt.Errorf("Rewrite must have a prefix or a regex, not both")
+	}
+	if len(rw.Prefix) == 0 && len(rw.Regex) == 0 {
+		if len(rw.Replace) > 0 {
+			return fmt.Errorf("Rewrite replacement given without a prefix or regex")
+		}
+		if len(rw.Params) == 0 {
+			return fmt.Errorf("Rewrite must have a prefix, a regex or params")
+		}
+	}
+	if len(rw.Regex) > 0 {
+		re, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return fmt.Errorf("Invalid rewrite regex: %v", err)
+		}
+		rw.regex = re
+	}
+	for from, to := range rw.Params {
+		if len(from) == 0 || len(to) == 0 {
+			return fmt.Errorf("Rewrite params must have names, not %q -> %q", from, to)
+		}
+	}
+
+	return nil
+}
+
+// RewritePath returns the path rewritten by the prefix or regex, if any
+func (rw *Rewrite) RewritePath(p string) string {
+	switch {
+	case len(rw.Prefix) > 0:
+		if strings.HasPrefix(p, rw.Prefix) {
+			return rw.Replace + p[len(rw.Prefix):]
+		}
+	case rw.regex != nil:
+		return rw.regex.ReplaceAllString(p, rw.Replace)
+	}
+	return p
+}
+
+// Apply rewrites a request's path and query parameters before it's dispatched, keeping the original path in the
+// X-H-Original-Path header
+func (rw *Rewrite) Apply(req *http.Request) {
+	if p := rw.RewritePath(req.URL.Path); p != req.URL.Path {
+		req.Header.Set(OriginalPathHeader, req.URL.Path)
+		req.URL.Path = p
+		req.URL.RawPath = ""
+	}
+
+	if len(rw.Params) == 0 {
+		return
+	}
+	q := req.URL.Query()
+	renamed := false
+	for from, to := range rw.Params {
+		if vs, ok := q[from]; ok {
+			delete(q, from)
+			q[to] = append(q[to], vs...)
+			renamed = true
+		}
+	}
+	if renamed {
+		req.URL.RawQuery = q.Encode()
+		req.Form = nil // so it's parsed again with the new names
+	}
+}
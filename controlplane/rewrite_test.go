@@ -0,0 +1,74 @@
+package controlplane
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteValidation(t *testing.T) {
+	cases := []struct {
+		rule  *Rule
+		valid bool
+	}{
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Prefix: "/v1/old", Replace: "/v1/new"}}, true},
+		{&Rule{Action: ActionProxyToH1, Rewrite: &Rewrite{Regex: "^/v1/order/([^/]+)/stop$", Replace: "/v1/order/$1/cancel"}}, true},
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Params: map[string]string{"city": "hob"}}}, true},
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Prefix: "/v1/old/", Params: map[string]string{"city": "hob"}}}, true},
+
+		// must rewrite something
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{}}, false},
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Replace: "/v1/new"}}, false},
+		// but not both ways
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Prefix: "/v1/old", Regex: "^/v1/old"}}, false},
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Regex: "^/v1/(old"}}, false},
+		{&Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Params: map[string]string{"city": ""}}}, false},
+		// and only when sending on to H1 or H2
+		{&Rule{Action: ActionThrottle, Rewrite: &Rewrite{Prefix: "/v1/old"}}, false},
+	}
+
+	for i, tc := range cases {
+		err := tc.rule.Compile()
+		if tc.valid {
+			assert.NoError(t, err, "Case %d", i)
+		} else {
+			assert.Error(t, err, "Case %d", i)
+		}
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	prefix := &Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Prefix: "/v1/old/", Replace: "/v1/new/"}}
+	regex := &Rule{Action: ActionSendToH2, Rewrite: &Rewrite{Regex: "^/v1/order/([^/]+)/stop$", Replace: "/v1/order/$1/cancel"}}
+	assert.NoError(t, prefix.Compile())
+	assert.NoError(t, regex.Compile())
+
+	assert.Equal(t, "/v1/new/ping", prefix.Rewrite.RewritePath("/v1/old/ping"))
+	assert.Equal(t, "/v1/other/ping", prefix.Rewrite.RewritePath("/v1/other/ping"))
+	assert.Equal(t, "/v1/order/123/cancel", regex.Rewrite.RewritePath("/v1/order/123/stop"))
+	assert.Equal(t, "/v1/order/123/stop/now", regex.Rewrite.RewritePath("/v1/order/123/stop/now"))
+}
+
+func TestRewriteApply(t *testing.T) {
+	rule := &Rule{Action: ActionSendToH2, Rewrite: &Rewrite{
+		Prefix:  "/v1/old/",
+		Replace: "/v1/new/",
+		Params:  map[string]string{"city": "hob"},
+	}}
+	assert.NoError(t, rule.Compile())
+
+	req, _ := http.NewRequest("GET", "http://api.example.com/v1/old/ping?city=LON&foo=bar", nil)
+	rule.Rewrite.Apply(req)
+	assert.Equal(t, "/v1/new/ping", req.URL.Path)
+	assert.Equal(t, "/v1/old/ping", req.Header.Get(OriginalPathHeader))
+	assert.Equal(t, "LON", req.URL.Query().Get("hob"))
+	assert.Equal(t, "", req.URL.Query().Get("city"))
+	assert.Equal(t, "bar", req.URL.Query().Get("foo"))
+
+	// requests we don't rewrite aren't marked as such
+	req, _ = http.NewRequest("GET", "http://api.example.com/v1/other/ping", nil)
+	rule.Rewrite.Apply(req)
+	assert.Equal(t, "/v1/other/ping", req.URL.Path)
+	assert.Equal(t, "", req.Header.Get(OriginalPathHeader))
+}
@@ -5,22 +5,29 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	log "github.com/cihub/seelog"
+
+	inst "github.com/HailoOSS/service/instrumentation"
 )
 
 const (
-	urlName = "api" // the thin API is referenced by this name (eg: rather than "hms")
+	urlName       = "api"                      // the thin API is referenced by this name (eg: rather than "hms")
+	splitTemplate = "controlplane.split.%s.%v" // counts requests sent each way by split rules, by rule ID and action
 )
 
 // A Router routes a request to a backend (H1, H2, or throttle) according to the first matching rule (rules are sorted
 // by specificity)
 type Router interface {
 	Route() *Rule
+	Hob() string
+	Source() string
 	GetHobMode() string
 	SetHob(string)
 	Region() (region *Region, version int64)
 	CorrectHostname(rw http.ResponseWriter) (err error, isCorrect bool, urls Urls, version int64)
+	H2Endpoint(path string) (service, endpoint string, params map[string]string, ok bool)
 }
 
 type RuleRouter struct {
@@ -41,13 +48,63 @@ func (r *RuleRouter) Route() *Rule {
 		}
 	}
 
-	for _, rule := range r.control.Rules() {
-		if rule.Matches(r.extractor) {
-			return rule
+	loadedCfg := r.control.loadedConfig()
+	now := time.Now()
+
+	var matched *Rule
+	var shadowed []*Rule
+	for _, rule := range loadedCfg.rules {
+		if r.evaluate(loadedCfg, rule, now) != "" {
+			continue
+		}
+		if rule.Shadow {
+			// note it, but keep going to find the rule we'll actually act upon
+			shadowed = append(shadowed, rule)
+			continue
 		}
+		matched = rule
+		break
+	}
+
+	// split rules send the request one of a number of ways
+	if resolved := matched.resolveSplit(r.extractor); resolved != matched {
+		inst.Counter(1.0, fmt.Sprintf(splitTemplate, matched.Id(), resolved.Action), 1)
+		matched = resolved
+	}
+
+	if len(shadowed) > 0 {
+		r.recordShadowMatches(shadowed, matched)
+	}
+
+	return matched
+}
+
+// evaluate tests a rule against the request at a given time, returning why it didn't match (or "" if it did)
+func (r *RuleRouter) evaluate(loadedCfg loadedCpConfig, rule *Rule, now time.Time) string {
+	hobLoc := func() *time.Location {
+		return loadedCfg.hobLocations.Find(r.extractor.Hob())
+	}
+
+	// check the schedule first, since it's cheap unless we need the HOB's timezone
+	if !rule.ActiveAt(now, hobLoc) {
+		return mismatchSchedule
 	}
+	return rule.mismatch(r.extractor)
+}
+
+// Hob returns the HOB of the request
+func (r *RuleRouter) Hob() string {
+	return r.extractor.Hob()
+}
 
-	return nil
+// Source returns the source of the request, in terms of "customer" or "driver"
+func (r *RuleRouter) Source() string {
+	return r.extractor.Source()
+}
+
+// H2Endpoint finds the H2 service and endpoint for a path from the H2 routes in config (see ControlPlane.H2Endpoint)
+func (r *RuleRouter) H2Endpoint(path string) (service, endpoint string, params map[string]string, ok bool) {
+	return r.control.H2Endpoint(path)
 }
 
 // GetHobMode returns the mode
@@ -68,9 +125,14 @@ func (r *RuleRouter) GetHobMode() string {
 // requests to for a given HTTP request
 func (r *RuleRouter) Region() (region *Region, version int64) {
 	loadedCfg := r.control.loadedConfig()
+	return r.region(loadedCfg, nil), loadedCfg.rConfigVersion
+}
+
+// region identifies the region we should be sending API requests to, appending the ID of each region considered on
+// the way (primary, then any failovers) to path, if given
+func (r *RuleRouter) region(loadedCfg loadedCpConfig, path *[]string) (region *Region) {
 	regionId := loadedCfg.hobRegions.Find(r.extractor.Hob())
 	region = loadedCfg.regions[regionId]
-	version = loadedCfg.rConfigVersion
 
 	// None found? Return the fallback region (the first one as found lexicographically by region ID)
 	if region == nil && len(loadedCfg.regions) > 0 {
@@ -84,12 +146,18 @@ func (r *RuleRouter) Region() (region *Region, version int64) {
 		log.Debugf("Unable to detect region from Hob, picking a default region %s", region)
 	}
 
+	if path != nil && region != nil {
+		*path = append(*path, region.Id)
+	}
 	if region == nil || region.IsOnline() {
 		return
 	}
 
 	// Try failovers
 	for _, foId := range region.Failover {
+		if path != nil {
+			*path = append(*path, foId)
+		}
 		if loadedCfg.regions[foId].IsOnline() {
 			region = loadedCfg.regions[foId]
 			return
@@ -139,13 +207,13 @@ func (r *RuleRouter) CorrectHostname(rw http.ResponseWriter) (err error, isCorre
 func (r *RuleRouter) forceRoute(routeStr string) *Rule {
 	switch strings.ToUpper(routeStr) {
 	case "H2":
-		return &Rule{Action: ActionSendToH2}
+		return &Rule{Action: ActionSendToH2, forced: true}
 	case "H1":
-		return &Rule{Action: ActionProxyToH1}
+		return &Rule{Action: ActionProxyToH1, forced: true}
 	case "DEPRECATE":
-		return &Rule{Action: ActionDeprecate}
+		return &Rule{Action: ActionDeprecate, forced: true}
 	case "THROTTLE":
-		return &Rule{Action: ActionThrottle}
+		return &Rule{Action: ActionThrottle, forced: true}
 	default:
 		return nil
 	}
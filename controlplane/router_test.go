@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"sync/atomic"
 	"testing"
+	"time"
 	"unsafe"
 
 	"github.com/stretchr/testify/assert"
@@ -208,6 +209,72 @@ func TestRoute(t *testing.T) {
 	}
 }
 
+func TestRouteSkipsExpiredRules(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	loadRules()
+	cp := New()
+
+	// expire the throttle rule
+	expired := time.Now().Add(-time.Hour)
+	for _, rule := range cp.Rules() {
+		if rule.Action == ActionThrottle {
+			rule.ActiveUntil = &expired
+		}
+	}
+
+	r := &RuleRouter{
+		extractor: &testExtractor{
+			hob:  "ATL",
+			path: "/v2/throttle",
+		},
+		control: cp,
+	}
+
+	if rule := r.Route(); rule != nil {
+		t.Errorf("Expecting the expired throttle rule to be skipped, got %v", rule.Action)
+	}
+}
+
+func TestRouteIgnoresShadowRules(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	loadRules()
+	cp := New()
+
+	// shadow the throttle rule
+	for _, rule := range cp.Rules() {
+		if rule.Action == ActionThrottle {
+			rule.Shadow = true
+		}
+	}
+
+	// nothing else matches ATL, so we get the default route
+	r := &RuleRouter{
+		extractor: &testExtractor{
+			hob:  "ATL",
+			path: "/v2/throttle",
+		},
+		control: cp,
+	}
+	if rule := r.Route(); rule != nil {
+		t.Errorf("Expecting the shadow throttle rule to be ignored, got %v", rule.Action)
+	}
+
+	// whereas LON carries on to the less specific H1 rule
+	r = &RuleRouter{
+		extractor: &testExtractor{
+			hob:  "LON",
+			path: "/v2/throttle",
+		},
+		control: cp,
+	}
+	rule := r.Route()
+	if rule == nil || rule.Action != ActionProxyToH1 {
+		t.Errorf("Expecting the shadow throttle rule to be passed over for H1, got %v", rule)
+	}
+}
+
 func BenchmarkRoute(b *testing.B) {
 	origConfigBuf := bytes.NewBuffer(config.Raw())
 	defer config.Load(origConfigBuf)
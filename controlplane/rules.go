@@ -6,25 +6,64 @@ import (
 	"hash/fnv"
 	"io"
 	"math/rand"
+	"regexp"
 	"sort"
 	"strings"
 )
 
-// Sort rules by specificity
+// Sort rules by specificity, breaking ties by key so the order (and so which rule wins) never depends on map order
 func (rs Rules) Sort() SortedRules {
 	if rs == nil {
 		return SortedRules{}
 	}
+	keys := make([]string, 0, len(rs))
+	for k := range rs {
+		keys = append(keys, k)
+	}
 	ret := make(SortedRules, len(rs))
-	i := 0
-	for _, r := range rs {
-		ret[i] = r
-		i++
+	for i, k := range keys {
+		ret[i] = rs[k]
 	}
-	sort.Sort(ret)
+	sort.Sort(newPrecedence(ret, keys))
 	return ret
 }
 
+// precedence sorts rules as SortedRules does, but works out the specificity of each rule only once, and breaks ties
+// by key
+type precedence struct {
+	rules       SortedRules
+	keys        []string
+	specificity []int
+}
+
+func newPrecedence(rules SortedRules, keys []string) *precedence {
+	p := &precedence{rules: rules, keys: keys, specificity: make([]int, len(rules))}
+	for i, r := range rules {
+		p.specificity[i] = r.Specificity()
+	}
+	return p
+}
+
+func (p *precedence) Len() int {
+	return len(p.rules)
+}
+
+func (p *precedence) Swap(i, j int) {
+	p.rules.Swap(i, j)
+	p.keys[i], p.keys[j] = p.keys[j], p.keys[i]
+	p.specificity[i], p.specificity[j] = p.specificity[j], p.specificity[i]
+}
+
+func (p *precedence) Less(i, j int) bool {
+	if p.rules[i].Weight != p.rules[j].Weight {
+		return p.rules[i].Weight > p.rules[j].Weight
+	}
+	if p.specificity[i] != p.specificity[j] {
+		return p.specificity[i] > p.specificity[j]
+	}
+	return p.keys[i] < p.keys[j]
+}
+
 // Add a rule to a map of rules
 func (rs Rules) Add(r *Rule) Rules {
 	rs[r.Id()] = r
@@ -57,9 +96,68 @@ func (s SortedRules) Validate() error {
 	return nil
 }
 
+// Compile prepares the matchers of every rule (eg: regular expressions and path templates) ahead of routing,
+// returning an error if any of them are invalid
+func (s SortedRules) Compile() error {
+	for _, r := range s {
+		if err := r.Compile(); err != nil {
+			return fmt.Errorf("Rule %s: %v", r.Id(), err)
+		}
+	}
+	return nil
+}
+
+// Compile prepares the matchers of a rule ahead of routing, returning an error if any are invalid
+func (r *Rule) Compile() error {
+	if r == nil {
+		return nil
+	}
+	r.id = ""
+	r.id = r.Id()
+	if err := r.compileSchedule(); err != nil {
+		return err
+	}
+	if err := r.compileSplit(); err != nil {
+		return err
+	}
+	if err := r.compileRedirect(); err != nil {
+		return err
+	}
+	if err := r.compileRewrite(); err != nil {
+		return err
+	}
+	if err := r.compileMirror(); err != nil {
+		return err
+	}
+	if err := r.compileUpstream(); err != nil {
+		return err
+	}
+	if err := r.compileMock(); err != nil {
+		return err
+	}
+	if err := r.compileUpgrade(); err != nil {
+		return err
+	}
+	if r.Match == nil {
+		return nil
+	}
+	return r.Match.compile()
+}
+
 // Matches tests if a route matches a request, wrapped with an extractor
 func (r *Rule) Matches(ext Extractor) bool {
-	return r != nil && r.Match != nil && r.Match.matches(ext)
+	return r.mismatch(ext) == ""
+}
+
+// mismatch tests if a route matches a request, returning why not (or "" if it matches)
+func (r *Rule) mismatch(ext Extractor) string {
+	if r == nil || r.Match == nil {
+		return mismatchNoMatch
+	}
+	if reason := r.Match.mismatch(ext); reason != "" {
+		return reason
+	}
+	return r.splitMismatch(ext)
 }
 
 // Specificity returns a number that tells us how specific this rule is - in
@@ -74,69 +172,262 @@ func (r *Rule) Specificity() int {
 		if len(r.Match.Source) > 0 {
 			s += 5
 		}
+		if len(r.Match.Method) > 0 {
+			s += 5
+		}
+		if len(r.Match.IPs) > 0 {
+			s += 5
+		}
+		if r.Match.AppVersion != nil {
+			s += 5
+		}
+		if len(r.Match.Expr) > 0 {
+			s += 5
+		}
+		// each header or parameter narrows things down as much as the source does
+		s += 5 * (len(r.Match.Headers) + len(r.Match.Params))
 		if len(r.Match.Path) > 0 {
 			s += 10
 		}
+		if len(r.Match.PathRegex) > 0 {
+			s += 10
+		}
+		if len(r.Match.PathTemplate) > 0 {
+			// templates match the whole path, so rank above prefixes, and exact paths above that
+			if r.Match.exactPathTemplate() {
+				s += 20
+			} else {
+				s += 15
+			}
+		}
 	}
 
 	return s
 }
 
-// Id generats a deterministic unique ID for a rule
+// Id generats a deterministic unique ID for a rule. Compiled rules remember theirs, since it's a hash of the whole rule
 func (r *Rule) Id() string {
+	if r.id != "" {
+		return r.id
+	}
 	b, _ := json.Marshal(r)
 	h := fnv.New32a()
 	h.Write(b)
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-// matches tests if a match matches an HTTP request
-func (m *Match) matches(ext Extractor) bool {
+// RuleId identifies the rule a request was routed by, for reporting: the rule's Id(), or "default" if no rule
+// matched, or "forced" if the route was forced via X-Hailo-Route
+func RuleId(r *Rule) string {
+	switch {
+	case r == nil:
+		return DefaultRuleId
+	case r.forced:
+		return ForcedRuleId
+	case r.splitFrom != nil:
+		return r.splitFrom.Id()
+	default:
+		return r.Id()
+	}
+}
+
+// compile parses and validates the path regex and template (if any), sampling, IPs, app version and expr, storing
+// them for use when matching
+func (m *Match) compile() error {
+	m.pathRegex, m.pathTemplate = nil, nil
+	m.compileHobs()
+
+	if err := m.compileSampling(); err != nil {
+		return err
+	}
+	if err := m.compileIPs(); err != nil {
+		return err
+	}
+	if err := m.compileAppVersion(); err != nil {
+		return err
+	}
+	if err := m.compileExpr(); err != nil {
+		return err
+	}
+
+	if len(m.PathRegex) > 0 {
+		re, err := regexp.Compile(m.PathRegex)
+		if err != nil {
+			return fmt.Errorf("Invalid path regex %q: %v", m.PathRegex, err)
+		}
+		m.pathRegex = re
+	}
+
+	if len(m.PathTemplate) > 0 {
+		tpl, err := parsePathTemplate(m.PathTemplate)
+		if err != nil {
+			return err
+		}
+		m.pathTemplate = tpl
+	}
+
+	for name, vm := range m.Headers {
+		if err := vm.compile(); err != nil {
+			return fmt.Errorf("Header %s: %v", name, err)
+		}
+	}
+	for name, vm := range m.Params {
+		if err := vm.compile(); err != nil {
+			return fmt.Errorf("Param %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// exactPathTemplate tells us if the match's path template has no placeholders, using the compiled template if there
+// is one
+func (m *Match) exactPathTemplate() bool {
+	tpl := m.pathTemplate
+	if tpl == nil {
+		var err error
+		if tpl, err = parsePathTemplate(m.PathTemplate); err != nil {
+			return false
+		}
+	}
+	return tpl.isExact()
+}
+
+// Mismatch reasons, named after the match criteria that failed
+const (
+	mismatchNoMatch      = "no match criteria"
+	mismatchPath         = "path"
+	mismatchPathTemplate = "pathTemplate"
+	mismatchPathRegex    = "pathRegex"
+	mismatchSource       = "source"
+	mismatchMethod       = "method"
+	mismatchHeader       = "header "
+	mismatchParam        = "param "
+	mismatchHob          = "regulatoryArea"
+	mismatchIP           = "ips"
+	mismatchAppVersion   = "appVersion"
+	mismatchExpr         = "expr"
+	mismatchSampler      = "sampler"
+	mismatchSchedule     = "schedule"
+)
+
+// mismatch tests a match against an HTTP request, returning the criteria that failed to match (or "" if it matches)
+func (m *Match) mismatch(ext Extractor) string {
 	// check path first (easiest)
 	if len(m.Path) > 0 && !strings.HasPrefix(ext.Path(), m.Path) {
-		return false
+		return mismatchPath
 	}
 
-	// check source, since we don't have to dig into the request
+	// then the path template and regex; if these haven't been compiled we can't match
+	if len(m.PathTemplate) > 0 {
+		if m.pathTemplate == nil {
+			return mismatchPathTemplate
+		}
+		if _, ok := m.pathTemplate.match(ext.Path()); !ok {
+			return mismatchPathTemplate
+		}
+	}
+	if len(m.PathRegex) > 0 && (m.pathRegex == nil || !m.pathRegex.MatchString(ext.Path())) {
+		return mismatchPathRegex
+	}
+
+	// check source and method, since we don't have to dig into the request
 	if len(m.Source) > 0 && ext.Source() != m.Source {
-		return false
+		return mismatchSource
+	}
+	if len(m.Method) > 0 && !withinCsv(strings.ToUpper(m.Method), ext.Method()) {
+		return mismatchMethod
+	}
+
+	// check the client's IP
+	if len(m.IPs) > 0 && !m.matchesIP(ext.RemoteIP()) {
+		return mismatchIP
+	}
+
+	// check the client app's platform and version
+	if m.AppVersion != nil && !m.AppVersion.matches(ext) {
+		return mismatchAppVersion
+	}
+
+	// check headers, then parameters (which may mean parsing the body)
+	for name, vm := range m.Headers {
+		if !vm.matches(ext.Header(name)) {
+			return mismatchHeader + name
+		}
+	}
+	for name, vm := range m.Params {
+		if !vm.matches(ext.Value(name)) {
+			return mismatchParam + name
+		}
 	}
 
 	// check regulatory area
-	if len(m.Hob) > 0 && !withinCsv(m.Hob, ext.Hob()) {
-		return false
+	if len(m.Hob) > 0 && !m.matchesHob(ext.Hob()) {
+		return mismatchHob
+	}
+
+	// check the expression, which may need any of the above; if it hasn't been compiled we can't match
+	if len(m.Expr) > 0 && (m.expr == nil || !m.expr(ext)) {
+		return mismatchExpr
 	}
 
 	// apply sampling
 	if !m.sample(ext) {
-		return false
+		return mismatchSampler
 	}
 
-	return true
+	return ""
 }
 
 // sample calculates if we should allow this request based on sampling
 // returns true if this request matches based on sample
 func (m *Match) sample(ext Extractor) bool {
 	switch m.Sampler {
-	case CustomerSampler:
-		return hashSample(ext.Value("customer"), m.Proportion)
-	case DriverSampler:
-		return hashSample(ext.Value("driver"), m.Proportion)
-	case DeviceSampler:
-		return hashSample(ext.Value("device"), m.Proportion)
-	case SessionSampler:
-		return hashSample(ext.Value("session_id"), m.Proportion)
+	case CustomerSampler, DriverSampler, DeviceSampler, SessionSampler, KeyedSampler:
+		v := m.sampleValue(ext)
+		if v == "" {
+			switch m.Blank {
+			case BlankNoMatch:
+				return false
+			case BlankRandom:
+				return randomSample(m.Proportion)
+			}
+			return true
+		}
+		return hashSample(m.salted(v), m.Proportion)
 	}
 
 	// default is random
+	return randomSample(m.Proportion)
+}
+
+// randomSample decides at random if we should sample, with the given probability
+func randomSample(prop float32) bool {
 	roll := rand.Float32()
-	if roll > m.Proportion {
+	if roll > prop {
 		return false
 	}
 	return true
 }
 
+// samplerValue extracts the value a sticky sampler samples by from a request (or "" for the random sampler)
+func samplerValue(ext Extractor, s Sampler) string {
+	switch s {
+	case CustomerSampler:
+		return ext.Value("customer")
+	case DriverSampler:
+		return ext.Value("driver")
+	case DeviceSampler:
+		return ext.Value("device")
+	case SessionSampler:
+		return ext.Value("session_id")
+	}
+	return ""
+}
+
+// sampleBuckets is how finely hashing samplers (and splits) divide up the values they hash
+const sampleBuckets = 1000000
+
 // hashSample hashes v into a number, then calculates a remainder and thus decides if we should sample or not
 func hashSample(v string, prop float32) bool {
 	// if blank, then we ALWAYS sample, on the basis that we can't fairly calculate random
@@ -150,12 +441,51 @@ func hashSample(v string, prop float32) bool {
 	if prop <= 0.0 {
 		return false
 	}
+	if hashBucket(v) > uint64(prop*sampleBuckets) {
+		return false
+	}
+	return true
+}
+
+// hashBucket hashes a value into one of sampleBuckets buckets, for hashing samplers and splits alike
+func hashBucket(v string) uint64 {
 	h := fnv.New64()
 	io.WriteString(h, v)
-	i := h.Sum64()
-	// split into 1000000 buckets
-	bucket := i % 1000000
-	if bucket > uint64(prop*1000000) {
+	return h.Sum64() % sampleBuckets
+}
+
+// compile parses and validates the regex, if any, storing it for use when matching
+func (vm *ValueMatch) compile() error {
+	if vm == nil {
+		return fmt.Errorf("Missing value match")
+	}
+
+	vm.regex = nil
+	if len(vm.Regex) > 0 {
+		re, err := regexp.Compile(vm.Regex)
+		if err != nil {
+			return fmt.Errorf("Invalid regex %q: %v", vm.Regex, err)
+		}
+		vm.regex = re
+	}
+	return nil
+}
+
+// matches tests if a single request value matches
+func (vm *ValueMatch) matches(v string) bool {
+	if vm == nil {
+		return false
+	}
+	if len(vm.Equals) == 0 && len(vm.Prefix) == 0 && len(vm.Regex) == 0 {
+		return len(v) > 0
+	}
+	if len(vm.Equals) > 0 && !withinCsv(vm.Equals, v) {
+		return false
+	}
+	if len(vm.Prefix) > 0 && !strings.HasPrefix(v, vm.Prefix) {
+		return false
+	}
+	if len(vm.Regex) > 0 && (vm.regex == nil || !vm.regex.MatchString(v)) {
 		return false
 	}
 	return true
@@ -165,7 +495,8 @@ func hashSample(v string, prop float32) bool {
 func withinCsv(csv, test string) bool {
 	values := strings.Split(csv, ",")
 	for _, v := range values {
-		if v == test {
+		// allow for spaces after commas, like "GET, POST"
+		if strings.TrimSpace(v) == test {
 			return true
 		}
 	}
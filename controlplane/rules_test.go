@@ -84,6 +84,185 @@ func TestSpecificity(t *testing.T) {
 	}
 }
 
+func TestSpecificityPathMatchers(t *testing.T) {
+	prefix := &Rule{Match: &Match{Path: "/v1/order"}}
+	regex := &Rule{Match: &Match{PathRegex: "^/v1/order/[^/]+/cancel$"}}
+	template := &Rule{Match: &Match{PathTemplate: "/v1/order/{id}/cancel"}}
+	exact := &Rule{Match: &Match{PathTemplate: "/v1/order/cancel"}}
+
+	if regex.Specificity() != prefix.Specificity() {
+		t.Errorf("Regex should rank equal to a prefix, got %v vs %v", regex.Specificity(), prefix.Specificity())
+	}
+	if template.Specificity() <= prefix.Specificity() {
+		t.Errorf("Template should rank above a prefix, got %v vs %v", template.Specificity(), prefix.Specificity())
+	}
+	if exact.Specificity() <= template.Specificity() {
+		t.Errorf("Exact path should rank above a template, got %v vs %v", exact.Specificity(), template.Specificity())
+	}
+
+	sorted := Rules{}.Add(prefix).Add(regex).Add(template).Add(exact).Sort()
+	if sorted[0] != exact || sorted[1] != template {
+		t.Error("Expecting exact then template rules to sort first")
+	}
+
+	// compiled templates rank the same as uncompiled ones
+	before := exact.Specificity()
+	if err := exact.Compile(); err != nil {
+		t.Fatalf("Unexpected compile error: %v", err)
+	}
+	if exact.Specificity() != before {
+		t.Errorf("Compiled exact path should rank as before, got %v vs %v", exact.Specificity(), before)
+	}
+}
+
+func TestSortTiesByKey(t *testing.T) {
+	rules := Rules{}
+	for _, k := range []string{"d", "b", "e", "a", "c"} {
+		rules[k] = &Rule{Match: &Match{Path: "/v1/order"}}
+	}
+
+	// whatever order the map gives us, ties always come out in key order
+	for i := 0; i < 20; i++ {
+		sorted := rules.Sort()
+		for j, k := range []string{"a", "b", "c", "d", "e"} {
+			if sorted[j] != rules[k] {
+				t.Fatalf("Expecting rule %s at %d", k, j)
+			}
+		}
+	}
+}
+
+func TestPathTemplateRuleMatch(t *testing.T) {
+	rule := &Rule{
+		Match:  &Match{Path: "/v1/order", PathTemplate: "/v1/order/{id}/cancel", Proportion: 1.0},
+		Action: ActionThrottle,
+	}
+
+	ext := &testExtractor{path: "/v1/order/123/cancel"}
+	if rule.Matches(ext) {
+		t.Error("Not expecting an uncompiled template to match")
+	}
+
+	if err := rule.Compile(); err != nil {
+		t.Fatalf("Unexpected compile error: %v", err)
+	}
+	if !rule.Matches(ext) {
+		t.Error("Expecting /v1/order/123/cancel to match")
+	}
+	if rule.Matches(&testExtractor{path: "/v1/order/123"}) {
+		t.Error("Not expecting /v1/order/123 to match")
+	}
+}
+
+func TestPathRegexRuleMatch(t *testing.T) {
+	rule := &Rule{
+		Match:  &Match{PathRegex: "^/v1/order/[0-9]+/cancel$", Proportion: 1.0},
+		Action: ActionThrottle,
+	}
+	if err := rule.Compile(); err != nil {
+		t.Fatalf("Unexpected compile error: %v", err)
+	}
+
+	if !rule.Matches(&testExtractor{path: "/v1/order/123/cancel"}) {
+		t.Error("Expecting /v1/order/123/cancel to match")
+	}
+	if rule.Matches(&testExtractor{path: "/v1/order/abc/cancel"}) {
+		t.Error("Not expecting /v1/order/abc/cancel to match")
+	}
+
+	invalid := &Rule{Match: &Match{PathRegex: "^/v1/order/[0-9+$"}}
+	if err := SortedRules([]*Rule{invalid}).Compile(); err == nil {
+		t.Error("Expecting an invalid regex to fail to compile")
+	}
+}
+
+func TestSpecificityRequestMatchers(t *testing.T) {
+	rule := &Rule{
+		Match: &Match{
+			Method:  "POST",
+			Headers: map[string]*ValueMatch{"User-Agent": {Prefix: "HailoCustomer/3.1"}},
+			Params:  map[string]*ValueMatch{"app": {Equals: "driver"}},
+		},
+	}
+	if rule.Specificity() != 15 {
+		t.Errorf("Method, header and param should equate to specificity of 15, got %v", rule.Specificity())
+	}
+}
+
+func TestMethodMatch(t *testing.T) {
+	rule := &Rule{
+		Match:  &Match{Method: "post,PUT", Proportion: 1.0},
+		Action: ActionThrottle,
+	}
+
+	if !rule.Matches(&testExtractor{method: "POST"}) {
+		t.Error("Expecting POST match")
+	}
+	if !rule.Matches(&testExtractor{method: "PUT"}) {
+		t.Error("Expecting PUT match")
+	}
+	if rule.Matches(&testExtractor{method: "GET"}) {
+		t.Error("Not expecting GET match")
+	}
+
+	// spaces after commas are ignored
+	rule = &Rule{
+		Match:  &Match{Method: "GET, POST", Proportion: 1.0},
+		Action: ActionThrottle,
+	}
+	if !rule.Matches(&testExtractor{method: "POST"}) {
+		t.Error("Expecting POST match with spaces in the CSV")
+	}
+}
+
+func TestHeaderAndParamMatch(t *testing.T) {
+	rule := &Rule{
+		Match: &Match{
+			Headers: map[string]*ValueMatch{
+				"User-Agent": {Regex: `^HailoCustomer/3\.[01]\.`},
+				"X-H-Source": {},
+			},
+			Params: map[string]*ValueMatch{
+				"app": {Equals: "customer,driver", Prefix: "cust"},
+			},
+			Proportion: 1.0,
+		},
+		Action: ActionThrottle,
+	}
+	if err := rule.Compile(); err != nil {
+		t.Fatalf("Unexpected compile error: %v", err)
+	}
+
+	testCases := []struct {
+		ua, source, app string
+		matches         bool
+	}{
+		{"HailoCustomer/3.1.2 (iOS)", "customer", "customer", true},
+		{"HailoCustomer/3.2.0 (iOS)", "customer", "customer", false},
+		{"HailoCustomer/3.1.2 (iOS)", "", "customer", false},
+		{"HailoCustomer/3.1.2 (iOS)", "customer", "driver", false},
+		{"HailoCustomer/3.1.2 (iOS)", "customer", "", false},
+	}
+	for _, tc := range testCases {
+		ext := &testExtractor{
+			headers: map[string]string{"User-Agent": tc.ua, "X-H-Source": tc.source},
+			values:  map[string]string{"app": tc.app},
+		}
+		if rule.Matches(ext) != tc.matches {
+			t.Errorf("Expecting match=%v for %+v", tc.matches, tc)
+		}
+	}
+
+	invalid := &Rule{Match: &Match{Params: map[string]*ValueMatch{"app": {Regex: "(["}}}}
+	if err := invalid.Compile(); err == nil {
+		t.Error("Expecting an invalid param regex to fail to compile")
+	}
+	missing := &Rule{Match: &Match{Headers: map[string]*ValueMatch{"User-Agent": nil}}}
+	if err := missing.Compile(); err == nil {
+		t.Error("Expecting a null header match to fail to compile")
+	}
+}
+
 func TestSourceMatch(t *testing.T) {
 	driverSource := &Rule{
 		Match:  &Match{Source: "driver", Proportion: 1.0},
@@ -155,3 +334,24 @@ func TestRegression_RuleNilPointers(t *testing.T) {
 	rule.Specificity()
 	rule.Matches(ext)
 }
+
+func TestRuleIdCompiled(t *testing.T) {
+	rule := &Rule{Match: &Match{Path: "/v1/order", Proportion: 1.0}, Action: ActionThrottle}
+	id := rule.Id()
+
+	if err := rule.Compile(); err != nil {
+		t.Fatalf("Unexpected compile error: %v", err)
+	}
+	if rule.Id() != id {
+		t.Errorf("Expecting the compiled rule to keep ID %s, got %s", id, rule.Id())
+	}
+
+	// compiling again picks up any changes
+	rule.Action = ActionProxyToH1
+	if err := rule.Compile(); err != nil {
+		t.Fatalf("Unexpected compile error: %v", err)
+	}
+	if rule.Id() == id {
+		t.Error("Expecting a changed rule to get a new ID")
+	}
+}
@@ -0,0 +1,94 @@
+package controlplane
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// BlankMatch matches requests without a value to sample by (the default)
+	BlankMatch = "match"
+	// BlankNoMatch doesn't match requests without a value to sample by
+	BlankNoMatch = "nomatch"
+	// BlankRandom samples requests without a value to sample by randomly
+	BlankRandom = "random"
+
+	sampleKeyParam  = "param"
+	sampleKeyHeader = "header"
+	sampleKeyCookie = "cookie"
+)
+
+// sampleKey is a compiled SampleBy entry: somewhere in a request to find a value to sample by
+type sampleKey struct {
+	kind, name string
+}
+
+// compileSampling validates the sampler, keys and blank policy of a match, parsing SampleBy
+func (m *Match) compileSampling() error {
+	m.sampleKeys = nil
+
+	switch m.Blank {
+	case "", BlankMatch, BlankNoMatch, BlankRandom:
+	default:
+		return fmt.Errorf("Invalid blank policy %q; must be %s, %s or %s", m.Blank, BlankMatch, BlankNoMatch, BlankRandom)
+	}
+
+	if m.Sampler != KeyedSampler {
+		if len(m.SampleBy) > 0 {
+			return fmt.Errorf("SampleBy given for the %v", m.Sampler)
+		}
+		return nil
+	}
+
+	if len(m.SampleBy) == 0 {
+		return fmt.Errorf("The %v must have something to sample by", m.Sampler)
+	}
+	keys := make([]sampleKey, len(m.SampleBy))
+	for i, s := range m.SampleBy {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return fmt.Errorf("Invalid sample key %q; must be like param:customer, header:X-H-Device or cookie:session", s)
+		}
+		switch parts[0] {
+		case sampleKeyParam, sampleKeyHeader, sampleKeyCookie:
+		default:
+			return fmt.Errorf("Invalid sample key %q; must be a param, header or cookie", s)
+		}
+		keys[i] = sampleKey{kind: parts[0], name: parts[1]}
+	}
+	m.sampleKeys = keys
+
+	return nil
+}
+
+// sampleValue finds the value to sample a request by: the first of the sample keys present for the keyed sampler, or
+// the fixed parameter of the other sticky samplers
+func (m *Match) sampleValue(ext Extractor) string {
+	if m.Sampler != KeyedSampler {
+		return samplerValue(ext, m.Sampler)
+	}
+
+	for _, k := range m.sampleKeys {
+		var v string
+		switch k.kind {
+		case sampleKeyParam:
+			v = ext.Value(k.name)
+		case sampleKeyHeader:
+			v = ext.Header(k.name)
+		case sampleKeyCookie:
+			v = ext.Cookie(k.name)
+		}
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// salted salts a value to sample by, so rules with different salts sample independent populations
+func (m *Match) salted(v string) string {
+	if m.Salt == "" {
+		return v
+	}
+	return m.Salt + ":" + v
+}
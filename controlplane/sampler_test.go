@@ -0,0 +1,109 @@
+package controlplane
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplingValidation(t *testing.T) {
+	cases := []struct {
+		match *Match
+		valid bool
+	}{
+		{&Match{Sampler: KeyedSampler, SampleBy: []string{"param:customer", "header:X-H-Device", "cookie:session"}}, true},
+		{&Match{Sampler: CustomerSampler, Blank: BlankNoMatch, Salt: "experiment-1"}, true},
+		{&Match{Sampler: KeyedSampler, SampleBy: []string{"param:customer"}, Blank: BlankRandom}, true},
+
+		{&Match{Sampler: KeyedSampler}, false},
+		{&Match{Sampler: KeyedSampler, SampleBy: []string{"customer"}}, false},
+		{&Match{Sampler: KeyedSampler, SampleBy: []string{"param:"}}, false},
+		{&Match{Sampler: KeyedSampler, SampleBy: []string{"query:customer"}}, false},
+		{&Match{Sampler: CustomerSampler, SampleBy: []string{"param:customer"}}, false},
+		{&Match{Sampler: CustomerSampler, Blank: "sometimes"}, false},
+	}
+
+	for i, tc := range cases {
+		err := tc.match.compile()
+		if tc.valid {
+			assert.NoError(t, err, "Case %d", i)
+		} else {
+			assert.Error(t, err, "Case %d", i)
+		}
+	}
+}
+
+func TestKeyedSamplerFallback(t *testing.T) {
+	m := &Match{
+		Sampler:    KeyedSampler,
+		SampleBy:   []string{"param:customer", "header:X-H-Device", "cookie:session"},
+		Proportion: 1,
+	}
+	assert.NoError(t, m.compile())
+
+	assert.Equal(t, "cust", m.sampleValue(&testExtractor{
+		values:  map[string]string{"customer": "cust"},
+		headers: map[string]string{"X-H-Device": "dev"},
+	}))
+	assert.Equal(t, "dev", m.sampleValue(&testExtractor{
+		headers: map[string]string{"X-H-Device": "dev"},
+		cookies: map[string]string{"session": "sess"},
+	}))
+	assert.Equal(t, "sess", m.sampleValue(&testExtractor{cookies: map[string]string{"session": "sess"}}))
+	assert.Equal(t, "", m.sampleValue(&testExtractor{}))
+
+	// cookies come from real requests too
+	r, _ := http.NewRequest("GET", "/v1/point", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "sess"})
+	assert.Equal(t, "sess", m.sampleValue(newExtractor(r)))
+}
+
+func TestBlankPolicy(t *testing.T) {
+	blank := &testExtractor{}
+	for _, tc := range []struct {
+		blank    string
+		expected int // out of 1000
+	}{
+		{"", 1000},
+		{BlankMatch, 1000},
+		{BlankNoMatch, 0},
+		{BlankRandom, 500},
+	} {
+		m := &Match{Sampler: KeyedSampler, SampleBy: []string{"param:customer"}, Proportion: 0.5, Blank: tc.blank}
+		assert.NoError(t, m.compile())
+		ct := 0
+		for i := 0; i < 1000; i++ {
+			if m.sample(blank) {
+				ct++
+			}
+		}
+		assert.InDelta(t, tc.expected, ct, 100, "Blank policy %q", tc.blank)
+	}
+}
+
+func TestSamplingSalt(t *testing.T) {
+	unsalted := &Match{Sampler: CustomerSampler, Proportion: 0.5}
+	salted := &Match{Sampler: CustomerSampler, Proportion: 0.5, Salt: "experiment-1"}
+	keyed := &Match{Sampler: KeyedSampler, SampleBy: []string{"param:customer"}, Proportion: 0.5}
+	assert.NoError(t, keyed.compile())
+
+	same, both := 0, 0
+	for i := 0; i < 1000; i++ {
+		ext := &testExtractor{values: map[string]string{"customer": fmt.Sprintf("customer%d", i)}}
+		u, s := unsalted.sample(ext), salted.sample(ext)
+		if u == s {
+			same++
+		}
+		if u && s {
+			both++
+		}
+		// without a salt, the keyed sampler picks the same customers as the customer sampler
+		assert.Equal(t, u, keyed.sample(ext))
+	}
+
+	// independent populations agree about half the time, and overlap about a quarter
+	assert.InDelta(t, 500, same, 100)
+	assert.InDelta(t, 250, both, 75)
+}
@@ -0,0 +1,151 @@
+package controlplane
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	timeOfDayFormat = "15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// hobLocations maps HOBs to their (loaded) timezones
+type hobLocations map[string]*time.Location
+
+// ActiveAt tests if a rule applies at the given time, based on its active period and any recurring windows. hobLoc
+// locates the timezone of the request's HOB, and is only called if a window doesn't specify its own timezone
+func (r *Rule) ActiveAt(t time.Time, hobLoc func() *time.Location) bool {
+	if r == nil {
+		return false
+	}
+	if r.ActiveFrom != nil && t.Before(*r.ActiveFrom) {
+		return false
+	}
+	if r.ActiveUntil != nil && !t.Before(*r.ActiveUntil) {
+		return false
+	}
+	if len(r.Windows) == 0 {
+		return true
+	}
+
+	for _, w := range r.Windows {
+		loc := w.location
+		if loc == nil {
+			loc = hobLoc()
+		}
+		if w.contains(t.In(loc)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSchedule validates the active period and windows of a rule
+func (r *Rule) compileSchedule() error {
+	if r.ActiveFrom != nil && r.ActiveUntil != nil && !r.ActiveUntil.After(*r.ActiveFrom) {
+		return fmt.Errorf("activeUntil (%v) must be after activeFrom (%v)", r.ActiveUntil, r.ActiveFrom)
+	}
+	for i, w := range r.Windows {
+		if err := w.compile(); err != nil {
+			return fmt.Errorf("Window %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// compile parses and validates a window, storing the results for use when matching
+func (w *Window) compile() error {
+	if w == nil {
+		return fmt.Errorf("Missing window")
+	}
+
+	start, err := time.Parse(timeOfDayFormat, w.Start)
+	if err != nil {
+		return fmt.Errorf("Invalid start %q, expecting HH:MM", w.Start)
+	}
+	end, err := time.Parse(timeOfDayFormat, w.End)
+	if err != nil {
+		return fmt.Errorf("Invalid end %q, expecting HH:MM", w.End)
+	}
+	w.start, w.end = start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+
+	w.days = nil
+	if len(w.Days) > 0 {
+		w.days = make(map[time.Weekday]bool, 7)
+		for _, d := range strings.Split(w.Days, ",") {
+			wd, ok := weekdays[strings.ToLower(strings.TrimSpace(d))]
+			if !ok {
+				return fmt.Errorf("Invalid day %q, expecting one of Mon,Tue,Wed,Thu,Fri,Sat,Sun", d)
+			}
+			w.days[wd] = true
+		}
+	}
+
+	w.location = nil
+	if len(w.Timezone) > 0 {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return fmt.Errorf("Invalid timezone %q: %v", w.Timezone, err)
+		}
+		w.location = loc
+	}
+
+	return nil
+}
+
+// contains tests if a (local) time falls within this window. A window whose end is before its start wraps past
+// midnight, and so belongs to the day it started on; a window whose end equals its start lasts all day
+func (w *Window) contains(t time.Time) bool {
+	mins := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	switch {
+	case w.start == w.end:
+	case w.start < w.end:
+		if mins < w.start || mins >= w.end {
+			return false
+		}
+	case mins >= w.start:
+	case mins < w.end:
+		day = t.AddDate(0, 0, -1).Weekday()
+	default:
+		return false
+	}
+
+	return w.days == nil || w.days[day]
+}
+
+// Find locates the timezone for a HOB, falling back to "default" if none found and UTC if that isn't present
+func (hl hobLocations) Find(hob string) *time.Location {
+	if loc, ok := hl[hob]; ok {
+		return loc
+	}
+	if loc, ok := hl["default"]; ok {
+		return loc
+	}
+	return time.UTC
+}
+
+// Locations loads the timezone for each HOB, returning an error if any are unknown
+func (ht HobTimezones) Locations() (hobLocations, error) {
+	result := make(hobLocations, len(ht))
+	for hob, tz := range ht {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timezone %q for HOB %s: %v", tz, hob, err)
+		}
+		result[hob] = loc
+	}
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package controlplane
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatalf("Bad time %s: %v", s, err)
+	}
+	return tm
+}
+
+func utcLoc() *time.Location {
+	return time.UTC
+}
+
+func TestActivePeriod(t *testing.T) {
+	from, until := mustTime(t, "2015-06-01T00:00:00Z"), mustTime(t, "2015-06-08T00:00:00Z")
+	rule := &Rule{ActiveFrom: &from, ActiveUntil: &until}
+	assert.NoError(t, rule.Compile())
+
+	assert.False(t, rule.ActiveAt(mustTime(t, "2015-05-31T23:59:59Z"), utcLoc))
+	assert.True(t, rule.ActiveAt(from, utcLoc))
+	assert.True(t, rule.ActiveAt(mustTime(t, "2015-06-07T23:59:59Z"), utcLoc))
+	assert.False(t, rule.ActiveAt(until, utcLoc))
+
+	// no period at all means always active
+	assert.True(t, (&Rule{}).ActiveAt(from, utcLoc))
+
+	backwards := &Rule{ActiveFrom: &until, ActiveUntil: &from}
+	assert.Error(t, backwards.Compile())
+}
+
+func TestWindows(t *testing.T) {
+	rule := &Rule{
+		Windows: []*Window{
+			{Days: "Mon,Tue", Start: "02:00", End: "04:00", Timezone: "UTC"},
+			{Days: "Sat", Start: "23:00", End: "01:00", Timezone: "UTC"},
+		},
+	}
+	assert.NoError(t, rule.Compile())
+
+	testCases := []struct {
+		at     string
+		active bool
+	}{
+		{"2015-06-01T02:00:00Z", true},  // Monday
+		{"2015-06-02T03:59:00Z", true},  // Tuesday
+		{"2015-06-02T04:00:00Z", false}, // Tuesday, after the window
+		{"2015-06-03T02:30:00Z", false}, // Wednesday
+		{"2015-06-06T23:30:00Z", true},  // Saturday night
+		{"2015-06-07T00:30:00Z", true},  // Sunday morning, but the window started on Saturday
+		{"2015-06-07T23:30:00Z", false}, // Sunday night
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.active, rule.ActiveAt(mustTime(t, tc.at), utcLoc), "At %s", tc.at)
+	}
+}
+
+func TestWindowHobTimezone(t *testing.T) {
+	rule := &Rule{Windows: []*Window{{Start: "02:00", End: "04:00"}}}
+	assert.NoError(t, rule.Compile())
+
+	locs, err := HobTimezones{"NYC": "America/New_York"}.Locations()
+	assert.NoError(t, err)
+	nycLoc := func() *time.Location { return locs.Find("NYC") }
+	lonLoc := func() *time.Location { return locs.Find("LON") }
+
+	// 07:00 UTC is 03:00 in New York (EDT), but we know nothing about LON so that's UTC
+	at := mustTime(t, "2015-06-01T07:00:00Z")
+	assert.True(t, rule.ActiveAt(at, nycLoc))
+	assert.False(t, rule.ActiveAt(at, lonLoc))
+}
+
+func TestWindowValidation(t *testing.T) {
+	invalid := []*Window{
+		{Start: "2am", End: "04:00"},
+		{Start: "02:00", End: "25:00"},
+		{Days: "Mon,Funday", Start: "02:00", End: "04:00"},
+		{Start: "02:00", End: "04:00", Timezone: "Mars/Olympus_Mons"},
+		nil,
+	}
+	for _, w := range invalid {
+		rule := &Rule{Windows: []*Window{w}}
+		assert.Error(t, rule.Compile(), "Expecting %+v to be invalid", w)
+	}
+
+	_, err := HobTimezones{"LON": "Europe/Londinium"}.Locations()
+	assert.Error(t, err)
+}
@@ -0,0 +1,44 @@
+package controlplane
+
+import (
+	"fmt"
+	"math/rand"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/service/config"
+	inst "github.com/HailoOSS/service/instrumentation"
+)
+
+const (
+	shadowMatchTemplate   = "controlplane.shadow.%s.match"
+	shadowDiffersTemplate = "controlplane.shadow.%s.differs"
+
+	// defaultShadowLogChance is the proportion of shadow matches we log, if not configured
+	defaultShadowLogChance = 0.01
+)
+
+// recordShadowMatches records that shadow rules would have matched this request, in place of the rule that actually
+// did (which may be nil, meaning the default route)
+func (r *RuleRouter) recordShadowMatches(shadowed []*Rule, matched *Rule) {
+	actual := ActionSendToH2 // the default route when no rules match
+	actualId := RuleId(matched)
+	if matched != nil {
+		actual = matched.Action
+	}
+
+	logChance := config.AtPath("hailo", "api", "controlPlane", "shadowLogPcChance").AsFloat64(defaultShadowLogChance)
+	for _, rule := range shadowed {
+		id := rule.Id()
+		inst.Counter(1.0, fmt.Sprintf(shadowMatchTemplate, id), 1)
+		if rule.Action != actual {
+			inst.Counter(1.0, fmt.Sprintf(shadowDiffersTemplate, id), 1)
+		}
+
+		if rand.Float64() < logChance {
+			log.Infof("[Control Plane] Shadow rule %s (%v) would have matched %s %s%s hob=%s source=%s; routed by %s (%v)",
+				id, rule.Action, r.extractor.Method(), r.extractor.Host(), r.extractor.Path(), r.extractor.Hob(),
+				r.extractor.Source(), actualId, actual)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package controlplane
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+const (
+	// splitSalt salts the values we split by, so splits are independent of any sampling by the same values
+	splitSalt = "split:"
+)
+
+// compileSplit validates the split of a rule, if it has one (and checks it does, if it's a split rule)
+func (r *Rule) compileSplit() error {
+	if r.Action != ActionSplit {
+		if r.Split != nil {
+			return fmt.Errorf("Split given for a %v rule", r.Action)
+		}
+		return nil
+	}
+
+	s := r.Split
+	if s == nil || len(s.Backends) == 0 {
+		return fmt.Errorf("Split rules must have backends")
+	}
+	switch s.Sampler {
+	case CustomerSampler, DriverSampler, DeviceSampler, SessionSampler:
+	default:
+		return fmt.Errorf("Split rules must have a sticky sampler (customer, driver, device or session), not %d", s.Sampler)
+	}
+
+	total := 0.0
+	for i, b := range s.Backends {
+		if b == nil {
+			return fmt.Errorf("Split backend %d is missing", i)
+		}
+		switch b.Action {
+		case ActionProxyToH1, ActionSendToH2, ActionThrottle:
+		default:
+			return fmt.Errorf("Split backend %d has an invalid action %v; must be H1, H2 or throttle", i, b.Action)
+		}
+		if b.Percent <= 0 {
+			return fmt.Errorf("Split backend %d has a percentage of %v; must be more than 0", i, b.Percent)
+		}
+		total += b.Percent
+	}
+	if math.Abs(total-100) > 1e-6 {
+		return fmt.Errorf("Split percentages add up to %v; must be 100", total)
+	}
+
+	return nil
+}
+
+// resolveSplit chooses the backend a request matching a split rule goes to, returning a rule with that backend's
+// action (or the rule itself, if it isn't a split)
+func (r *Rule) resolveSplit(ext Extractor) *Rule {
+	if r == nil || r.Action != ActionSplit || r.Split == nil || len(r.Split.Backends) == 0 {
+		return r
+	}
+
+	v := samplerValue(ext, r.Split.Sampler)
+	if v != "" {
+		if r.Match != nil {
+			v = r.Match.salted(v)
+		}
+		// the match may have sampled by the same value, so hash something else, or only those in the first buckets
+		// (which the match let through) would ever be split. Not the rule ID, which changes with the percentages
+		v = splitSalt + v
+	}
+	backend := r.Split.choose(v)
+	return &Rule{
+		Action:    backend.Action,
+		Payload:   backend.Payload,
+		Rewrite:   r.Rewrite,
+		splitFrom: r,
+	}
+}
+
+// splitMismatch is why a split rule doesn't match a request its match otherwise would: with a blank policy of
+// nomatch, requests without a value for the split's sampler (or "" if it does match, or isn't a split rule)
+func (r *Rule) splitMismatch(ext Extractor) string {
+	if r.Action != ActionSplit || r.Split == nil || r.Match == nil || r.Match.Blank != BlankNoMatch {
+		return ""
+	}
+	if samplerValue(ext, r.Split.Sampler) == "" {
+		return mismatchSampler
+	}
+	return ""
+}
+
+// choose picks a backend for a sampled value, such that the same value always gets the same backend, and those
+// given the first backends keep them if their percentages grow. Blank values (which we can't be sticky with) are
+// allocated at random, unless the rule's blank policy means they never get this far
+func (s *Split) choose(v string) *SplitBackend {
+	var pc float64
+	if v == "" {
+		pc = rand.Float64() * 100
+	} else {
+		pc = float64(hashBucket(v)) / sampleBuckets * 100
+	}
+
+	cumulative := 0.0
+	for _, b := range s.Backends {
+		cumulative += b.Percent
+		if pc < cumulative {
+			return b
+		}
+	}
+	// rounding errors might leave us short
+	return s.Backends[len(s.Backends)-1]
+}
@@ -0,0 +1,208 @@
+package controlplane
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitValidation(t *testing.T) {
+	backends := func(pcs ...float64) []*SplitBackend {
+		result := make([]*SplitBackend, len(pcs))
+		for i, pc := range pcs {
+			result[i] = &SplitBackend{Action: ActionSendToH2, Percent: pc}
+		}
+		return result
+	}
+
+	cases := []struct {
+		rule  *Rule
+		valid bool
+	}{
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(25, 75)}}, true},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: SessionSampler, Backends: backends(33.3, 33.3, 33.4)}}, true},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: DeviceSampler, Backends: backends(100)}}, true},
+		{&Rule{Action: ActionSendToH2}, true},
+
+		// percentages must add up to 100
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(25, 70)}}, false},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(50, 60)}}, false},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(0, 100)}}, false},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(-10, 110)}}, false},
+		// samplers must be sticky
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: RandomSampler, Backends: backends(50, 50)}}, false},
+		// backends must be H1, H2 or throttle
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: []*SplitBackend{
+			{Action: ActionSplit, Percent: 100},
+		}}}, false},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: []*SplitBackend{
+			{Action: ActionDeprecate, Percent: 100},
+		}}}, false},
+		// splits need a split action, and vice versa
+		{&Rule{Action: ActionSplit}, false},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler}}, false},
+		{&Rule{Action: ActionSendToH2, Split: &Split{Sampler: CustomerSampler, Backends: backends(100)}}, false},
+		// backends can't mirror, proxy to upstreams or tell apps to upgrade
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(100)},
+			Mirror: &Mirror{}}, false},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(100)},
+			Upstream: "foo"}, false},
+		{&Rule{Action: ActionSplit, Split: &Split{Sampler: CustomerSampler, Backends: backends(100)},
+			Upgrade: &Upgrade{}}, false},
+	}
+
+	for i, tc := range cases {
+		err := tc.rule.Compile()
+		if tc.valid {
+			assert.NoError(t, err, "Case %d", i)
+		} else {
+			assert.Error(t, err, "Case %d", i)
+		}
+	}
+}
+
+func TestSplitChoose(t *testing.T) {
+	h1 := &SplitBackend{Action: ActionProxyToH1, Percent: 80}
+	h2 := &SplitBackend{Action: ActionSendToH2, Percent: 20}
+	s := &Split{Sampler: CustomerSampler, Backends: []*SplitBackend{h2, h1}}
+
+	counts := map[*SplitBackend]int{}
+	onH2 := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		customer := fmt.Sprintf("customer%d", i)
+		b := s.choose(customer)
+		counts[b]++
+		onH2[customer] = b == h2
+
+		// sticky
+		assert.Equal(t, b, s.choose(customer))
+	}
+	assert.InDelta(t, 2000, counts[h2], 200)
+	assert.InDelta(t, 8000, counts[h1], 200)
+
+	// as we move more to H2, those already on H2 stay there
+	h2.Percent, h1.Percent = 50, 50
+	for customer, wasOnH2 := range onH2 {
+		if wasOnH2 {
+			assert.Equal(t, h2, s.choose(customer), "Expecting %s to stay on H2", customer)
+		}
+	}
+
+	// blank values are allocated, but randomly
+	assert.NotNil(t, s.choose(""))
+}
+
+func TestRouteSplit(t *testing.T) {
+	split := &Rule{
+		Action: ActionSplit,
+		Match:  &Match{Path: "/v1/order", Proportion: 1},
+		Split: &Split{
+			Sampler: CustomerSampler,
+			Backends: []*SplitBackend{
+				{Action: ActionSendToH2, Percent: 50},
+				{Action: ActionThrottle, Percent: 50, Payload: &Payload{HttpStatus: 503}},
+			},
+		},
+	}
+	assert.NoError(t, split.Compile())
+	cp := &ControlPlane{}
+	atomic.StorePointer(&cp._loadedConfig, unsafe.Pointer(&loadedCpConfig{rules: SortedRules{split}}))
+
+	counts := map[Action]int{}
+	for i := 0; i < 1000; i++ {
+		r := &RuleRouter{
+			extractor: &testExtractor{
+				path:   "/v1/order",
+				values: map[string]string{"customer": fmt.Sprintf("%d", i)},
+			},
+			control: cp,
+		}
+		rule := r.Route()
+		if !assert.NotNil(t, rule) {
+			return
+		}
+		counts[rule.Action]++
+		assert.Equal(t, split.Id(), RuleId(rule), "Expecting split routes to be attributed to the split rule")
+		if rule.Action == ActionThrottle {
+			assert.Equal(t, 503, rule.Payload.HttpStatus)
+		}
+	}
+	assert.InDelta(t, 500, counts[ActionSendToH2], 100)
+	assert.InDelta(t, 500, counts[ActionThrottle], 100)
+	assert.Equal(t, 0, counts[ActionSplit])
+}
+
+func TestRouteSplitSaltAndBlank(t *testing.T) {
+	split := func(salt, blank string) *Rule {
+		return &Rule{
+			Action: ActionSplit,
+			Match:  &Match{Path: "/v1/order", Proportion: 1, Salt: salt, Blank: blank},
+			Split: &Split{
+				Sampler: CustomerSampler,
+				Backends: []*SplitBackend{
+					{Action: ActionSendToH2, Percent: 50},
+					{Action: ActionProxyToH1, Percent: 50},
+				},
+			},
+		}
+	}
+	unsalted, salted, noBlanks := split("", ""), split("foo", ""), split("", BlankNoMatch)
+	for _, rule := range []*Rule{unsalted, salted, noBlanks} {
+		assert.NoError(t, rule.Compile())
+	}
+	ext := func(customer string) *testExtractor {
+		return &testExtractor{path: "/v1/order", values: map[string]string{"customer": customer}}
+	}
+
+	// salted splits pick backends independently of unsalted ones
+	differ := 0
+	for i := 0; i < 1000; i++ {
+		e := ext(fmt.Sprintf("%d", i))
+		if unsalted.resolveSplit(e).Action != salted.resolveSplit(e).Action {
+			differ++
+		}
+	}
+	assert.InDelta(t, 500, differ, 100)
+
+	// by default, requests without a customer are allocated at random; with nomatch, they don't match at all
+	assert.Equal(t, "", unsalted.mismatch(ext("")))
+	assert.Equal(t, mismatchSampler, noBlanks.mismatch(ext("")))
+	assert.Equal(t, "", noBlanks.mismatch(ext("123")))
+}
+
+func TestRouteSplitPartialStickyMatch(t *testing.T) {
+	// half the customers match, and those are split half and half, independently of the match
+	split := &Rule{
+		Action: ActionSplit,
+		Match:  &Match{Path: "/v1/order", Proportion: 0.5, Sampler: CustomerSampler},
+		Split: &Split{
+			Sampler: CustomerSampler,
+			Backends: []*SplitBackend{
+				{Action: ActionProxyToH1, Percent: 50},
+				{Action: ActionSendToH2, Percent: 50},
+			},
+		},
+	}
+	assert.NoError(t, split.Compile())
+	cp := &ControlPlane{}
+	atomic.StorePointer(&cp._loadedConfig, unsafe.Pointer(&loadedCpConfig{rules: SortedRules{split}}))
+
+	counts := map[Action]int{}
+	for i := 0; i < 10000; i++ {
+		r := &RuleRouter{
+			extractor: &testExtractor{
+				path:   "/v1/order",
+				values: map[string]string{"customer": fmt.Sprintf("customer%d", i)},
+			},
+			control: cp,
+		}
+		if rule := r.Route(); rule != nil {
+			counts[rule.Action]++
+		}
+	}
+	assert.InDelta(t, 2500, counts[ActionProxyToH1], 250)
+	assert.InDelta(t, 2500, counts[ActionSendToH2], 250)
+}
@@ -1,5 +1,12 @@
 package controlplane
 
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+)
+
 // Extractor allows us to extract stuff about requests when matching
 type Extractor interface {
 	Hob() string               // Hob is the city code
@@ -9,6 +16,9 @@ type Extractor interface {
 	Source() string            // Source is whether this came from "customer" or "driver" API - expecting return one of these two
 	Host() string              // Host of request
 	Header(name string) string // Header is some HTTP header
+	Cookie(name string) string // Cookie is the value of some HTTP cookie
+	RemoteIP() net.IP          // RemoteIP is the client's IP address (resolved by RealIPHandler), or nil if unknown
+	Method() string            // Method is the HTTP method (verb) of the request, eg: GET
 }
 
 // Rules represents a set of unsorted rules, indexed by UID
@@ -23,15 +33,152 @@ type Rule struct {
 	Action  Action   `json:"action,omitempty"`
 	Payload *Payload `json:"payload,omitempty"`
 	Weight  int      `json:"weight,omitempty"`
+	Shadow  bool     `json:"shadow,omitempty"` // Shadow rules are never acted upon, we just record when they would have matched
+
+	ActiveFrom  *time.Time `json:"activeFrom,omitempty"`  // ActiveFrom is when this rule starts applying (inclusive)
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"` // ActiveUntil is when this rule stops applying (exclusive)
+	Windows     []*Window  `json:"windows,omitempty"`     // Windows, if any, restrict this rule to recurring periods
+
+	Split    *Split    `json:"split,omitempty"`    // Split divides requests between backends, for split rules
+	Redirect *Redirect `json:"redirect,omitempty"` // Redirect is where to send clients, for redirect rules
+	Rewrite  *Rewrite  `json:"rewrite,omitempty"`  // Rewrite, if any, changes the request before it's sent to H1 or H2
+	Mirror   *Mirror   `json:"mirror,omitempty"`   // Mirror, if any, copies H1 requests to H2 and compares the responses
+	Upstream string    `json:"upstream,omitempty"` // Upstream is the name of the upstream to proxy to, for upstream rules
+	Mock     *Mock     `json:"mock,omitempty"`     // Mock is the response to serve, for mock rules
+	Upgrade  *Upgrade  `json:"upgrade,omitempty"`  // Upgrade tells clients where to get a new app, for upgrade rules
+
+	forced    bool   // forced is set on the rules we make up when a route is forced via X-Hailo-Route
+	splitFrom *Rule  // splitFrom is set on the rules we make up for the backend chosen by a split rule
+	id        string // id is the Id() of the rule, worked out by Compile() so we don't on every request
+}
+
+// Split divides requests between backends by percentage, sticking with the same backend for the same sampled value
+// (eg: the same customer)
+type Split struct {
+	Sampler  Sampler         `json:"sampler,omitempty"`  // Sampler must be sticky (customer, driver, device or session)
+	Backends []*SplitBackend `json:"backends,omitempty"` // Backends' percentages must add up to 100
+}
+
+// SplitBackend is one side of a split
+type SplitBackend struct {
+	Action  Action   `json:"action,omitempty"`  // Action is H1, H2 or throttle
+	Percent float64  `json:"percent,omitempty"` // Percent of requests to send this way
+	Payload *Payload `json:"payload,omitempty"` // Payload for throttled requests
+}
+
+// Redirect tells clients to go elsewhere. The target may reference the original request with placeholders: {path},
+// {query} (without the ?) and {hob}, like https://api.example.com/v2{path}?{query}
+type Redirect struct {
+	Target string `json:"target,omitempty"` // Target URL template
+	Status int    `json:"status,omitempty"` // Status is 301, 302, 307 or 308 - blank for 302
+}
+
+// Rewrite changes the path and query parameters of a request before it's dispatched, so old client paths keep
+// working when endpoints are renamed. The path is rewritten by either a prefix or a regex
+type Rewrite struct {
+	Prefix  string            `json:"prefix,omitempty"`  // Prefix of the path to replace with Replace, like /v1/old
+	Regex   string            `json:"regex,omitempty"`   // Regex to substitute with Replace, which may use $1 etc, like ^/v1/order/([^/]+)/stop$
+	Replace string            `json:"replace,omitempty"` // Replace is what to replace the prefix or regex match with
+	Params  map[string]string `json:"params,omitempty"`  // Params are query parameters to rename, from old name to new
+
+	regex *regexp.Regexp // compiled Regex, populated by compile()
+}
+
+// Mirror sends copies of requests served by H1 to H2 in the background, comparing the responses, so we can check H2
+// behaves the same before migrating an endpoint
+type Mirror struct {
+	Proportion float32  `json:"proportion,omitempty"` // Proportion is a float from 0 to 1 of requests to mirror
+	Ignore     []string `json:"ignore,omitempty"`     // Ignore these JSON fields when comparing, as dotted paths like payload.eta
+}
+
+// Mock is a canned response, served in place of a real backend. The body and headers may reference the request with
+// placeholders: {{hob}}, {{path}} and {{requestId}} (from X-Request-Id, or made up)
+type Mock struct {
+	Body       string            `json:"body,omitempty"`       // Body to respond with
+	BodyFile   string            `json:"bodyFile,omitempty"`   // BodyFile to read the body from instead, within MockDir - read when config is loaded
+	HttpStatus int               `json:"httpStatus,omitempty"` // HttpStatus to respond with - blank for 200
+	Headers    map[string]string `json:"headers,omitempty"`    // Headers to respond with - JSON content type unless given
+	Latency    string            `json:"latency,omitempty"`    // Latency to add before responding, like 200ms
+
+	// compiled forms of the above, populated by compile() when config is loaded
+	body    string
+	bodyErr error // bodyErr is why BodyFile couldn't be read, if it couldn't
+	json    bool  // json is set if the body is JSON, so placeholders must be escaped
+	latency time.Duration
+}
+
+// Upgrade tells clients their app is too old to use, and where to get a new one
+type Upgrade struct {
+	StoreUrls map[string]string `json:"storeUrls,omitempty"` // StoreUrls by platform (ios, android or windows), with "default" for any other
+	Message   string            `json:"message,omitempty"`   // Message for the client - blank for "Upgrade required"
+}
+
+// Window represents a recurring period of local time, such as a daily maintenance window
+type Window struct {
+	Days     string `json:"days,omitempty"`     // Days is a CSV of weekdays the window starts on, like Mon,Tue - blank for every day
+	Start    string `json:"start,omitempty"`    // Start is the local time of day the window opens, like 02:00
+	End      string `json:"end,omitempty"`      // End is the local time of day the window closes, like 04:00 (may be before Start to wrap past midnight)
+	Timezone string `json:"timezone,omitempty"` // Timezone is an IANA name like Europe/London - blank to use the timezone of the request's HOB
+
+	// compiled forms of the above, populated by compile() when config is loaded
+	days       map[time.Weekday]bool
+	start, end int // minutes since midnight
+	location   *time.Location
 }
 
 // Match represents some criteria to match an HTTP request against
 type Match struct {
-	Hob        string  `json:"regulatoryArea,omitempty"` // Hob is our city code, worked out either from hostname or explicit city=FOO parameter (POST or GET)
-	Path       string  `json:"path,omitempty"`           // Path is the pathname, like /v1/foo/bar
-	Source     string  `json:"source,omitempty"`         // Source is either "customer" or "driver" or "", where we work this out from the hostname (contains customer or driver or not)
-	Proportion float32 `json:"proportion,omitempty"`     // Proportion is a float from 0 to 1 that gives us sampling
-	Sampler    Sampler `json:"sampler,omitempty"`        // Sampler tells us how to sample
+	Hob          string  `json:"regulatoryArea,omitempty"` // Hob is a CSV of our city codes (or @ HOB groups), worked out either from hostname or explicit city=FOO parameter (POST or GET)
+	Path         string  `json:"path,omitempty"`           // Path is a pathname prefix, like /v1/foo/bar
+	PathRegex    string  `json:"pathRegex,omitempty"`      // PathRegex is a regular expression the pathname must match, like ^/v1/order/[^/]+/cancel$
+	PathTemplate string  `json:"pathTemplate,omitempty"`   // PathTemplate must match the whole pathname, where {name} or * match one segment, like /v1/order/{id}/cancel
+	Source       string  `json:"source,omitempty"`         // Source is either "customer" or "driver" or "", where we work this out from the hostname (contains customer or driver or not)
+	Method       string  `json:"method,omitempty"`         // Method is a CSV of HTTP methods, like GET,POST
+	Proportion   float32 `json:"proportion,omitempty"`     // Proportion is a float from 0 to 1 that gives us sampling
+	Sampler      Sampler `json:"sampler,omitempty"`        // Sampler tells us how to sample
+
+	SampleBy []string `json:"sampleBy,omitempty"` // SampleBy lists where the keyed sampler looks for a value, in order, like param:customer, header:X-H-Device or cookie:session
+	Blank    string   `json:"blank,omitempty"`    // Blank is what hashing samplers do without a value: match (the default), nomatch or random
+	Salt     string   `json:"salt,omitempty"`     // Salt for hashing samplers, so different rules can pick independent populations
+
+	IPs        []string         `json:"ips,omitempty"`        // IPs is a list of client IP addresses or CIDR ranges (IPv4 or IPv6), like 10.0.0.0/8
+	AppVersion *AppVersionMatch `json:"appVersion,omitempty"` // AppVersion matches the platform and version of the client app
+
+	Headers map[string]*ValueMatch `json:"headers,omitempty"` // Headers match HTTP header values, indexed by header name
+	Params  map[string]*ValueMatch `json:"params,omitempty"`  // Params match POST or GET values, indexed by parameter name
+
+	Expr string `json:"expr,omitempty"` // Expr is an expression that must be true, like hob == "LON" || header("X-H-Beta") != ""
+
+	// compiled forms of the above, populated by compile() when config is loaded
+	pathRegex    *regexp.Regexp
+	pathTemplate pathTemplate
+	sampleKeys   []sampleKey
+	ipNets       []*net.IPNet
+	expr         func(ext Extractor) bool
+	hobs         map[string]bool // the regulatory area, with HOB groups expanded by expandHobGroups()
+	hobGroups    []string        // names of the HOB groups the regulatory area refers to
+}
+
+// AppVersionMatch matches the platform and semantic version of the client app, found in request headers like
+// User-Agent: Hailo/3.1.4 (iOS 9.2). If a version is needed but we can't find one, the request doesn't match
+type AppVersionMatch struct {
+	Headers  []string `json:"headers,omitempty"`  // Headers to look in, in order - blank for X-H-App-Version, X-H-Platform then User-Agent
+	Platform string   `json:"platform,omitempty"` // Platform is a CSV of ios, android or windows - blank for any
+	Version  string   `json:"version,omitempty"`  // Version is a CSV of constraints that must all hold, like >= 3.0, < 3.2.0
+
+	// compiled forms of the above, populated by compile()
+	platforms   map[string]bool
+	constraints []versionConstraint
+}
+
+// ValueMatch represents some criteria to match a single request value (eg: a header) against. All criteria given must
+// match, and if none are given the value need only be present
+type ValueMatch struct {
+	Equals string `json:"equals,omitempty"` // Equals is a CSV of exact values, like the Match Hob
+	Prefix string `json:"prefix,omitempty"` // Prefix the value must begin with
+	Regex  string `json:"regex,omitempty"`  // Regex is a regular expression the value must match
+
+	regex *regexp.Regexp // compiled Regex, populated by compile()
 }
 
 // Sampler defines how we should sample requests for matching
@@ -48,6 +195,8 @@ const (
 	DeviceSampler Sampler = 3
 	// SessionSampler samples by "api_token" or "session_id" POST or GET parameter
 	SessionSampler Sampler = 4
+	// KeyedSampler samples by the first value found from the match's SampleBy
+	KeyedSampler Sampler = 5
 )
 
 // Action represents some outcome that is attached to a route, telling us what we should do next
@@ -58,6 +207,12 @@ const (
 	ActionSendToH2  Action = 2
 	ActionThrottle  Action = 3
 	ActionDeprecate Action = 4
+	ActionSplit     Action = 5
+	ActionRedirect  Action = 6
+
+	ActionProxyToUpstream Action = 7
+	ActionMock            Action = 8
+	ActionUpgrade         Action = 9
 )
 
 func (a Action) String() string {
@@ -71,6 +226,16 @@ func (a Action) String() string {
 		return "Throttle"
 	case ActionDeprecate:
 		return "Deprecate"
+	case ActionSplit:
+		return "Split"
+	case ActionRedirect:
+		return "Redirect"
+	case ActionProxyToUpstream:
+		return "Upstream"
+	case ActionMock:
+		return "Mock"
+	case ActionUpgrade:
+		return "Upgrade"
 	default:
 		return "?"
 	}
@@ -97,10 +262,66 @@ type Region struct {
 	Apps     map[string]Urls `json:"apps,omitempty"`     // Apps and their URL config for pinning
 }
 
+// Upstreams represents named pools of HTTP servers we can proxy requests to, indexed by name
+type Upstreams map[string]*Upstream
+
+// Upstream defines a pool of HTTP servers, which we proxy to in turn
+type Upstream struct {
+	Urls            []string     `json:"urls,omitempty"`                // Urls of the servers, like http://orders.internal:8080 (or without a scheme, to use Scheme)
+	Scheme          string       `json:"scheme,omitempty"`              // Scheme is http or https for Urls without one - blank for https
+	Tls             *UpstreamTls `json:"tls,omitempty"`                 // Tls settings for https servers
+	DialTimeout     string       `json:"dialTimeout,omitempty"`         // DialTimeout is how long to wait to connect, like 2s - blank for 5s
+	ResponseTimeout string       `json:"responseTimeout,omitempty"`     // ResponseTimeout is how long to wait for response headers, like 10s - blank for 30s
+	MaxIdleConns    int          `json:"maxIdleConnsPerHost,omitempty"` // MaxIdleConns is how many idle connections to keep to each server - blank for 5
+
+	// compiled forms of the above, populated by Compile() when config is loaded
+	targets                      []*url.URL
+	dialTimeout, responseTimeout time.Duration
+}
+
+// UpstreamTls defines how we connect to https upstream servers
+type UpstreamTls struct {
+	ServerName         string `json:"serverName,omitempty"`         // ServerName to verify certificates against, if not the host we connect to
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"` // InsecureSkipVerify accepts any certificate - only for testing!
+}
+
 // HobRegions maps HOBs to primary regions
 type HobRegions map[string]string
 
+// HobGroups are named lists of HOBs, which rules can refer to in their regulatory area like @EU_H1_CITIES, rather
+// than listing the same HOBs over and over
+type HobGroups map[string][]string
+
+// HostHobs map the hostnames requests are made to onto HOBs, for clients (like H1 drivers) which don't tell us their
+// HOB
+type HostHobs []*HostHob
+
+// HostHob maps a hostname, or hostnames, onto a HOB
+type HostHob struct {
+	Host  string `json:"host,omitempty"`  // Host is an exact hostname, or *.example.com for any subdomain of example.com
+	Regex string `json:"regex,omitempty"` // Regex the hostname must match, instead of Host, like ^api-driver-london(-test)?\.
+	Hob   string `json:"hob,omitempty"`   // Hob of requests to matching hostnames
+
+	regex *regexp.Regexp // compiled Regex, populated by compile()
+}
+
+// H2Routes map paths onto H2 API-tier services, for APIs that don't fit the usual convention (where /v1/foo/bar calls
+// the bar endpoint of com.HailoOSS.api.v1.foo). The first route whose template matches is used
+type H2Routes []*H2Route
+
+// H2Route sends requests with paths matching a template to an explicit service and endpoint
+type H2Route struct {
+	PathTemplate string `json:"pathTemplate,omitempty"` // PathTemplate like /v1/order/{id}/cancel; placeholders become GET params
+	Service      string `json:"service,omitempty"`      // Service to call, eg: com.HailoOSS.api.order
+	Endpoint     string `json:"endpoint,omitempty"`     // Endpoint of the service to call, eg: cancel
+
+	pathTemplate pathTemplate // parsed PathTemplate, populated by compile()
+}
+
 // HobModes maps HOBs to modes
 type HobModes map[string]string
 
+// HobTimezones maps HOBs to IANA timezone names, like Europe/London
+type HobTimezones map[string]string
+
 //go:generate stringer -type=Sampler -output=types_string.go
@@ -4,9 +4,9 @@ package controlplane
 
 import "fmt"
 
-const _Sampler_name = "RandomSamplerCustomerSamplerDriverSamplerDeviceSamplerSessionSampler"
+const _Sampler_name = "RandomSamplerCustomerSamplerDriverSamplerDeviceSamplerSessionSamplerKeyedSampler"
 
-var _Sampler_index = [...]uint8{0, 13, 28, 41, 54, 68}
+var _Sampler_index = [...]uint8{0, 13, 28, 41, 54, 68, 80}
 
 func (i Sampler) String() string {
 	if i < 0 || i+1 >= Sampler(len(_Sampler_index)) {
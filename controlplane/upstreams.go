@@ -0,0 +1,152 @@
+package controlplane
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUpstreamScheme          = "https"
+	defaultUpstreamDialTimeout     = 5 * time.Second
+	defaultUpstreamResponseTimeout = 30 * time.Second
+	defaultUpstreamIdleConns       = 5
+)
+
+// Compile validates every upstream, parsing their URLs and timeouts for use when proxying
+func (us Upstreams) Compile() error {
+	for name, u := range us {
+		if u == nil {
+			return fmt.Errorf("Upstream %s is missing", name)
+		}
+		if err := u.compile(); err != nil {
+			return fmt.Errorf("Upstream %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (u *Upstream) compile() error {
+	if len(u.Urls) == 0 {
+		return fmt.Errorf("Must have at least one URL")
+	}
+
+	scheme := defaultUpstreamScheme
+	if len(u.Scheme) > 0 {
+		scheme = strings.ToLower(u.Scheme)
+	}
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("Scheme must be http or https, not %s", u.Scheme)
+	}
+
+	targets := make([]*url.URL, len(u.Urls))
+	for i, raw := range u.Urls {
+		if !strings.Contains(raw, "://") {
+			raw = scheme + "://" + raw
+		}
+		target, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("Invalid URL %s: %v", u.Urls[i], err)
+		}
+		if (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+			return fmt.Errorf("Invalid URL %s: must be http or https, with a host", u.Urls[i])
+		}
+		targets[i] = target
+	}
+
+	dialTimeout, err := parseUpstreamTimeout(u.DialTimeout, defaultUpstreamDialTimeout)
+	if err != nil {
+		return fmt.Errorf("Invalid dial timeout: %v", err)
+	}
+	responseTimeout, err := parseUpstreamTimeout(u.ResponseTimeout, defaultUpstreamResponseTimeout)
+	if err != nil {
+		return fmt.Errorf("Invalid response timeout: %v", err)
+	}
+	if u.MaxIdleConns < 0 {
+		return fmt.Errorf("Max idle connections must not be negative")
+	}
+
+	u.targets, u.dialTimeout, u.responseTimeout = targets, dialTimeout, responseTimeout
+	return nil
+}
+
+func parseUpstreamTimeout(s string, def time.Duration) (time.Duration, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be more than 0", s)
+	}
+	return d, nil
+}
+
+// Targets returns the parsed URLs of the upstream's servers
+func (u *Upstream) Targets() []*url.URL {
+	return u.targets
+}
+
+// DialTimeoutDuration returns how long to wait to connect to a server, defaulting to 5s
+func (u *Upstream) DialTimeoutDuration() time.Duration {
+	if u.dialTimeout == 0 {
+		return defaultUpstreamDialTimeout
+	}
+	return u.dialTimeout
+}
+
+// ResponseTimeoutDuration returns how long to wait for a server's response headers, defaulting to 30s
+func (u *Upstream) ResponseTimeoutDuration() time.Duration {
+	if u.responseTimeout == 0 {
+		return defaultUpstreamResponseTimeout
+	}
+	return u.responseTimeout
+}
+
+// IdleConns returns how many idle connections to keep to each server, defaulting to 5
+func (u *Upstream) IdleConns() int {
+	if u.MaxIdleConns == 0 {
+		return defaultUpstreamIdleConns
+	}
+	return u.MaxIdleConns
+}
+
+// compileUpstream checks an upstream rule names an upstream (and that other rules don't)
+func (r *Rule) compileUpstream() error {
+	if r.Action != ActionProxyToUpstream {
+		if len(r.Upstream) > 0 {
+			return fmt.Errorf("Upstream given for a %v rule", r.Action)
+		}
+		return nil
+	}
+	if len(r.Upstream) == 0 {
+		return fmt.Errorf("Upstream rules must name an upstream")
+	}
+	return nil
+}
+
+// ValidateUpstreams checks every upstream rule names an upstream that exists
+func (s SortedRules) ValidateUpstreams(us Upstreams) error {
+	for _, r := range s {
+		if r != nil && r.Action == ActionProxyToUpstream && us[r.Upstream] == nil {
+			return fmt.Errorf("Rule %s: unknown upstream %s", r.Id(), r.Upstream)
+		}
+	}
+	return nil
+}
+
+// Upstreams obtains the current upstreams from the control plane
+func (cp *ControlPlane) Upstreams() Upstreams {
+	if cp == nil {
+		return nil
+	}
+	return cp.loadedConfig().upstreams
+}
+
+// Upstream finds an upstream by name, returning nil if there's no such upstream
+func (cp *ControlPlane) Upstream(name string) *Upstream {
+	return cp.Upstreams()[name]
+}
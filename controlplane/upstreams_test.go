@@ -0,0 +1,101 @@
+package controlplane
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamCompile(t *testing.T) {
+	u := &Upstream{Urls: []string{"orders-1.internal:8080", "http://orders-2.internal:8080/orders"}}
+	assert.NoError(t, u.compile())
+	if assert.Len(t, u.Targets(), 2) {
+		assert.Equal(t, "https://orders-1.internal:8080", u.Targets()[0].String())
+		assert.Equal(t, "http://orders-2.internal:8080/orders", u.Targets()[1].String())
+	}
+	assert.Equal(t, 5*time.Second, u.DialTimeoutDuration())
+	assert.Equal(t, 30*time.Second, u.ResponseTimeoutDuration())
+	assert.Equal(t, 5, u.IdleConns())
+
+	u = &Upstream{Urls: []string{"orders.internal"}, Scheme: "http", DialTimeout: "500ms", ResponseTimeout: "2s",
+		MaxIdleConns: 20}
+	assert.NoError(t, u.compile())
+	assert.Equal(t, "http://orders.internal", u.Targets()[0].String())
+	assert.Equal(t, 500*time.Millisecond, u.DialTimeoutDuration())
+	assert.Equal(t, 2*time.Second, u.ResponseTimeoutDuration())
+	assert.Equal(t, 20, u.IdleConns())
+
+	invalid := []*Upstream{
+		{},
+		{Urls: []string{"orders.internal"}, Scheme: "ftp"},
+		{Urls: []string{"ftp://orders.internal"}},
+		{Urls: []string{"http://"}},
+		{Urls: []string{"orders.internal"}, DialTimeout: "soon"},
+		{Urls: []string{"orders.internal"}, ResponseTimeout: "-1s"},
+		{Urls: []string{"orders.internal"}, MaxIdleConns: -1},
+	}
+	for i, u := range invalid {
+		assert.Error(t, u.compile(), "Case %d", i)
+	}
+}
+
+func TestUpstreamRules(t *testing.T) {
+	assert.NoError(t, (&Rule{Action: ActionProxyToUpstream, Upstream: "orders"}).Compile())
+	assert.Error(t, (&Rule{Action: ActionProxyToUpstream}).Compile())
+	assert.Error(t, (&Rule{Action: ActionSendToH2, Upstream: "orders"}).Compile())
+
+	rules := SortedRules{
+		{Action: ActionSendToH2, Match: &Match{Path: "/v1/point"}},
+		{Action: ActionProxyToUpstream, Upstream: "orders", Match: &Match{Path: "/v1/order"}},
+	}
+	assert.NoError(t, rules.ValidateUpstreams(Upstreams{"orders": {Urls: []string{"orders.internal"}}}))
+	assert.Error(t, rules.ValidateUpstreams(Upstreams{"points": {Urls: []string{"points.internal"}}}))
+}
+
+func TestLoadUpstreams(t *testing.T) {
+	cp := &ControlPlane{}
+	err := cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":7,"upstream":"orders","match":{"path":"/v1/order","proportion":1}}},
+		"upstreams":{"orders":{"urls":["orders.internal:8080"],"scheme":"http"}}}}`), "pinned")
+	assert.NoError(t, err)
+	if u := cp.Upstream("orders"); assert.NotNil(t, u) {
+		assert.Equal(t, "http://orders.internal:8080", u.Targets()[0].String())
+	}
+
+	// rules must refer to upstreams that exist
+	err = cp.load([]byte(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":7,"upstream":"points","match":{"path":"/v1/order","proportion":1}}},
+		"upstreams":{"orders":{"urls":["orders.internal:8080"]}}}}`), "pinned")
+	assert.Error(t, err)
+	assert.NotNil(t, cp.Upstream("orders"), "Expecting the previous config to still be loaded")
+}
+
+func TestLintUpstreams(t *testing.T) {
+	lint := func(rules, upstreams string) Problems {
+		return Lint([]byte(`{"controlPlane":{"rules":{` + rules + `},"upstreams":{` + upstreams + `},` +
+			lintRegionsJson + `}}`))
+	}
+	orders := `"a":{"action":7,"upstream":"orders","match":{"path":"/v1/order","proportion":1}}`
+	assert.Empty(t, lint(orders, `"orders":{"urls":["orders.internal"]}`))
+
+	// unknown upstreams, and those which won't compile, stop the config loading
+	testCases := []struct {
+		rules, upstreams, message string
+	}{
+		{orders + `,"b":{"action":7,"upstream":"points","match":{"path":"/v1/point","proportion":1}}`,
+			`"orders":{"urls":["orders.internal"]}`, "unknown upstream points"},
+		{orders, `"orders":{"urls":["orders.internal"]},"drivers":{"urls":["drivers.internal"],"dialTimeout":"soon"}`,
+			"Upstream drivers"},
+	}
+	for i, tc := range testCases {
+		problems := lint(tc.rules, tc.upstreams)
+		p := problemAt(problems, "$.controlPlane")
+		if assert.NotNil(t, p, "Case %d: expecting a problem; got %v", i, problems) {
+			assert.Equal(t, SeverityError, p.Severity, "Case %d", i)
+			assert.Contains(t, p.Message, tc.message, "Case %d", i)
+		}
+	}
+}
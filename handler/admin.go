@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	"github.com/HailoOSS/api-proxy/session"
+	"github.com/HailoOSS/api-proxy/stats"
+)
+
+// explainRequest is a synthetic request, POSTed to the explain endpoint, that we evaluate against the control plane
+type explainRequest struct {
+	Method   string            `json:"method"`
+	Host     string            `json:"host"`
+	Path     string            `json:"path"`
+	Params   map[string]string `json:"params"`
+	Headers  map[string]string `json:"headers"`
+	RemoteIP string            `json:"remoteIp"`
+}
+
+// httpRequest builds an HTTP request equivalent to this synthetic request, with params in the query string
+func (er *explainRequest) httpRequest() (*http.Request, error) {
+	method := er.Method
+	if method == "" {
+		method = "GET"
+	}
+	path := er.Path
+	if path == "" {
+		path = "/"
+	}
+
+	q := url.Values{}
+	for k, v := range er.Params {
+		q.Set(k, v)
+	}
+	u := &url.URL{Scheme: "http", Host: er.Host, Path: path, RawQuery: q.Encode()}
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range er.Headers {
+		req.Header.Set(k, v)
+	}
+	if er.RemoteIP != "" {
+		req.RemoteAddr = net.JoinHostPort(er.RemoteIP, "0")
+	}
+	return req, nil
+}
+
+// adminAuthorised checks the session in the request belongs to an ADMIN
+func adminAuthorised(r *http.Request) bool {
+	sessId := session.SessionId(r)
+	if sessId == "" {
+		return false
+	}
+
+	scope := authScopeConstructor()
+	if err := scope.RecoverSession(sessId); err != nil {
+		log.Debugf("[Admin] Failed to recover session: %v", err)
+		return false
+	}
+	return scope.IsAuth() && scope.AuthUser().HasRole("ADMIN")
+}
+
+// writeAdminError writes out an error response from one of the admin endpoints
+func writeAdminError(rw http.ResponseWriter, status int, code, payload string) {
+	rw.WriteHeader(status)
+	fmt.Fprint(rw, jsonResponse{
+		"status":      false,
+		"code":        status,
+		"dotted_code": "com.HailoOSS.hailo-2-api.admin." + code,
+		"payload":     payload,
+	})
+}
+
+// ExplainHandler evaluates a synthetic request against the control plane, describing every rule considered and where
+// the request would be routed
+func ExplainHandler(srv *HailoServer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !adminAuthorised(r) {
+			writeAdminError(rw, http.StatusForbidden, "forbidden", "Permission denied.")
+			return
+		}
+		if r.Method != "POST" {
+			writeAdminError(rw, http.StatusMethodNotAllowed, "methodnotallowed", "Must POST a request to explain")
+			return
+		}
+
+		er := &explainRequest{}
+		if err := json.NewDecoder(r.Body).Decode(er); err != nil {
+			writeAdminError(rw, http.StatusBadRequest, "badrequest", fmt.Sprintf("Invalid request JSON: %v", err))
+			return
+		}
+		req, err := er.httpRequest()
+		if err != nil {
+			writeAdminError(rw, http.StatusBadRequest, "badrequest", fmt.Sprintf("Invalid request: %v", err))
+			return
+		}
+
+		fmt.Fprint(rw, jsonResponse{
+			"status":      true,
+			"payload":     "OK",
+			"explanation": srv.Control.Explain(req),
+		})
+	}
+}
+
+// ruleStatsEntry is the stats of a single rule, as served up by the rule stats endpoint
+type ruleStatsEntry struct {
+	Id    string             `json:"id"`
+	Rule  *controlplane.Rule `json:"rule,omitempty"`
+	Stats stats.RuleStat     `json:"stats"`
+}
+
+// RuleStatsHandler serves up hit, error and latency stats for every rule currently loaded (in order, including those
+// never hit), and for requests routed by default or forced via X-Hailo-Route
+func RuleStatsHandler(srv *HailoServer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !adminAuthorised(r) {
+			writeAdminError(rw, http.StatusForbidden, "forbidden", "Permission denied.")
+			return
+		}
+
+		rules := srv.Control.Rules()
+		entries := make([]*ruleStatsEntry, 0, len(rules)+2)
+		for _, rule := range rules {
+			id := rule.Id()
+			entries = append(entries, &ruleStatsEntry{Id: id, Rule: rule, Stats: stats.GetRuleStat(id)})
+		}
+		for _, id := range []string{controlplane.DefaultRuleId, controlplane.ForcedRuleId} {
+			entries = append(entries, &ruleStatsEntry{Id: id, Stats: stats.GetRuleStat(id)})
+		}
+
+		fmt.Fprint(rw, jsonResponse{
+			"status":  true,
+			"payload": "OK",
+			"rules":   entries,
+		})
+	}
+}
+
+// ConfigHistoryHandler serves up the last few generations of control plane config loaded, and what changed in each
+func ConfigHistoryHandler(srv *HailoServer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !adminAuthorised(r) {
+			writeAdminError(rw, http.StatusForbidden, "forbidden", "Permission denied.")
+			return
+		}
+
+		fmt.Fprint(rw, jsonResponse{
+			"status":      true,
+			"payload":     "OK",
+			"generations": srv.Control.History(),
+		})
+	}
+}
+
+// LastGoodConfigsHandler lists the generations of "last good" config we have on file (newest first), and the one
+// we're pinned to, if any
+func LastGoodConfigsHandler(srv *HailoServer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !adminAuthorised(r) {
+			writeAdminError(rw, http.StatusForbidden, "forbidden", "Permission denied.")
+			return
+		}
+
+		gens, err := controlplane.LastGoodConfigs()
+		if err != nil {
+			writeAdminError(rw, http.StatusInternalServerError, "lastgood", fmt.Sprintf("Failed to list last good "+
+				"config: %v", err))
+			return
+		}
+
+		fmt.Fprint(rw, jsonResponse{
+			"status":      true,
+			"payload":     "OK",
+			"pinned":      srv.Control.Pinned(),
+			"generations": gens,
+		})
+	}
+}
+
+// PinConfigHandler pins the control plane to the "last good" config generation POSTed as "generation", ignoring
+// the config service until unpinned
+func PinConfigHandler(srv *HailoServer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !adminAuthorised(r) {
+			writeAdminError(rw, http.StatusForbidden, "forbidden", "Permission denied.")
+			return
+		}
+		if r.Method != "POST" {
+			writeAdminError(rw, http.StatusMethodNotAllowed, "methodnotallowed", "Must POST a generation to pin")
+			return
+		}
+
+		id := r.FormValue("generation")
+		if id == "" {
+			writeAdminError(rw, http.StatusBadRequest, "badrequest", "Missing generation")
+			return
+		}
+		if err := srv.Control.Pin(id); err != nil {
+			writeAdminError(rw, http.StatusBadRequest, "pin", fmt.Sprintf("Failed to pin config: %v", err))
+			return
+		}
+
+		fmt.Fprint(rw, jsonResponse{
+			"status":  true,
+			"payload": "OK",
+			"pinned":  id,
+		})
+	}
+}
+
+// UnpinConfigHandler goes back to following the config service after a pin
+func UnpinConfigHandler(srv *HailoServer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if !adminAuthorised(r) {
+			writeAdminError(rw, http.StatusForbidden, "forbidden", "Permission denied.")
+			return
+		}
+		if r.Method != "POST" {
+			writeAdminError(rw, http.StatusMethodNotAllowed, "methodnotallowed", "Must POST to unpin")
+			return
+		}
+
+		if err := srv.Control.Unpin(); err != nil {
+			// we're unpinned regardless, and will keep trying to load from the config service as it changes
+			writeAdminError(rw, http.StatusInternalServerError, "unpin", fmt.Sprintf("Unpinned, but failed to load "+
+				"config: %v", err))
+			return
+		}
+
+		fmt.Fprint(rw, jsonResponse{
+			"status":  true,
+			"payload": "OK",
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainRequestToHTTP(t *testing.T) {
+	er := &explainRequest{
+		Method:   "POST",
+		Host:     "api2.elasticride.com",
+		Path:     "/v1/order",
+		Params:   map[string]string{"city": "LON"},
+		Headers:  map[string]string{"X-H-Source": "customer"},
+		RemoteIP: "2001:db8::1",
+	}
+
+	req, err := er.httpRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "api2.elasticride.com", req.Host)
+	assert.Equal(t, "/v1/order", req.URL.Path)
+	assert.Equal(t, "LON", req.URL.Query().Get("city"))
+	assert.Equal(t, "customer", req.Header.Get("X-H-Source"))
+	assert.Equal(t, "[2001:db8::1]:0", req.RemoteAddr)
+
+	// defaults
+	req, err = (&explainRequest{}).httpRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, "GET", req.Method)
+	assert.Equal(t, "/", req.URL.Path)
+}
+
+func TestExplainHandlerRequiresAdmin(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/explain", strings.NewReader(`{"path":"/v1/order"}`))
+	rw := httptest.NewRecorder()
+
+	ExplainHandler(&HailoServer{})(rw, req)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+}
@@ -20,7 +20,7 @@ import (
 )
 
 // h2Handler sends a request via H2, encoding the HTTP request as proto for an API-tier service
-func h2Handler(rw http.ResponseWriter, r *http.Request, router controlplane.Router) {
+func h2Handler(rw http.ResponseWriter, r *http.Request, router controlplane.Router, ruleId string) {
 	// map request -> proto, dispatch, map proto response -> http, respond
 	start := time.Now()
 	success := false
@@ -48,41 +48,12 @@ func h2Handler(rw http.ResponseWriter, r *http.Request, router controlplane.Rout
 	// trace this request?
 	traceInfo := trace.Start(r)
 
-	// map request to proto
-	protoReq, perr := httpRequestToProto(r)
+	rsp, perr := dispatchH2(r, traceInfo, router, ruleId)
 	if perr != nil {
 		h2error.Write(rw, perr, "application/json", traceInfo)
 		return
 	}
 
-	// dispatch request to api handler, inferred from path
-	service, ep := pathToEndpoint(r.URL.Path)
-	request, err := client.NewRequest(service, ep, protoReq)
-	if err != nil {
-		log.Debugf("Failed to translate to H2 request: %v", err)
-		perr := errors.BadRequest(
-			"15",
-			"No handler available.",
-		)
-		h2error.Write(rw, perr, "application/json", traceInfo)
-		return
-	}
-
-	// Add scope
-	if traceInfo.TraceId != "" {
-		request.SetTraceID(traceInfo.TraceId)
-		request.SetTraceShouldPersist(traceInfo.PersistentTrace)
-	}
-	request.SetSessionID(session.SessionId(r))
-	request.SetFrom("com.HailoOSS.hailo-2-api")
-	request.SetRemoteAddr(r.RemoteAddr)
-
-	rsp := &api.Response{}
-	if perr := client.Req(request, rsp, client.Options{"retries": 0}); perr != nil {
-		h2error.Write(rw, perr, "application/json", traceInfo)
-		return
-	}
-
 	// add any trace details to output
 	trace.Write(rw, traceInfo)
 
@@ -107,13 +78,77 @@ func h2Handler(rw http.ResponseWriter, r *http.Request, router controlplane.Rout
 	regionPinning(router, rw)
 
 	rw.WriteHeader(int(rsp.GetStatusCode()))
-	rw.Write([]byte(rsp.GetBody()))
+	rw.Write(responseBody(rsp))
 
 	if rsp.GetStatusCode() < 500 {
 		success = true
 	}
 }
 
+// h2Route is where a request goes in H2, and what we tell the service about it: everything we need from the router,
+// so a request can be sent once the router (and the request it wraps) are done with, as mirrored requests are
+type h2Route struct {
+	metadata          *api.Request_Metadata
+	service, endpoint string
+	params            map[string]string // params from the H2 route's path template, replacing any the client sent
+}
+
+// routeH2 works out where a request goes in H2: from the H2 routes in config, or else inferred from its path. The
+// router (which may be nil) and rule ID are passed on as metadata
+func routeH2(r *http.Request, router controlplane.Router, ruleId string) *h2Route {
+	route := &h2Route{metadata: requestMetadata(r, router, ruleId)}
+	route.service, route.endpoint = pathToEndpoint(r.URL.Path)
+	if router != nil {
+		if s, e, params, ok := router.H2Endpoint(r.URL.Path); ok {
+			route.service, route.endpoint, route.params = s, e, params
+		}
+	}
+	return route
+}
+
+// dispatchH2 maps a request to proto and sends it to the API-tier service for it (see routeH2)
+func dispatchH2(r *http.Request, traceInfo *trace.APITraceInfo, router controlplane.Router,
+	ruleId string) (*api.Response, errors.Error) {
+	return sendH2(r, traceInfo, routeH2(r, router, ruleId))
+}
+
+// sendH2 maps a request to proto and sends it to H2 by the given route
+func sendH2(r *http.Request, traceInfo *trace.APITraceInfo, route *h2Route) (*api.Response, errors.Error) {
+	// map request to proto
+	protoReq, perr := httpRequestToProto(r, route.metadata)
+	if perr != nil {
+		return nil, perr
+	}
+	for k, v := range route.params {
+		setGetPair(protoReq, k, v)
+	}
+
+	// dispatch request to api handler
+	request, err := client.NewRequest(route.service, route.endpoint, protoReq)
+	if err != nil {
+		log.Debugf("Failed to translate to H2 request: %v", err)
+		return nil, errors.BadRequest(
+			"15",
+			"No handler available.",
+		)
+	}
+
+	// Add scope
+	if traceInfo != nil && traceInfo.TraceId != "" {
+		request.SetTraceID(traceInfo.TraceId)
+		request.SetTraceShouldPersist(traceInfo.PersistentTrace)
+	}
+	request.SetSessionID(session.SessionId(r))
+	request.SetFrom("com.HailoOSS.hailo-2-api")
+	request.SetRemoteAddr(r.RemoteAddr)
+
+	rsp := &api.Response{}
+	if perr := client.Req(request, rsp, client.Options{"retries": 0}); perr != nil {
+		return nil, perr
+	}
+	return rsp, nil
+}
+
 func pathToEndpoint(p string) (service, endpoint string) {
 	p = path.Clean(p)
 	p = strings.TrimPrefix(p, "/")
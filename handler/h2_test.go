@@ -90,7 +90,7 @@ func (s *H2HandlerSuite) TestNormal() {
 	s.NoError(err, "Request construction error")
 
 	// Expect a service-to-service call
-	expectedRequestPayload, err := httpRequestToProto(request)
+	expectedRequestPayload, err := httpRequestToProto(request, requestMetadata(request, nil, ""))
 	s.NoError(err)
 	expectedRequest, err := client.NewRequest("com.HailoOSS.api.h2-test", "foo", expectedRequestPayload)
 	s.NoError(err, "Error constructing expected service request")
@@ -125,7 +125,7 @@ func (s *H2HandlerSuite) TestOverwriteContentType() {
 	s.NoError(err, "Request construction error")
 
 	// Expect a service-to-service call
-	expectedRequestPayload, err := httpRequestToProto(request)
+	expectedRequestPayload, err := httpRequestToProto(request, requestMetadata(request, nil, ""))
 	s.NoError(err)
 	expectedRequest, err := client.NewRequest("com.HailoOSS.api.h2-test", "foo", expectedRequestPayload)
 	s.NoError(err, "Error constructing expected service request")
@@ -172,7 +172,7 @@ func (s *H2HandlerSuite) TestPCIFilter() {
 	s.NoError(err, "Request construction error")
 
 	// Expect a service-to-service call
-	expectedRequestPayload, err := httpRequestToProto(request)
+	expectedRequestPayload, err := httpRequestToProto(request, requestMetadata(request, nil, ""))
 	s.NoError(err)
 	expectedRequest, err := client.NewRequest("com.HailoOSS.api.v1.customer", "card", expectedRequestPayload)
 	s.NoError(err, "Error constructing expected service request")
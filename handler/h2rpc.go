@@ -22,6 +22,7 @@ import (
 const (
 	protoMime           = "application/x-protobuf"
 	formEncodedMime     = "application/x-www-form-urlencoded"
+	multipartMime       = "multipart/form-data"
 	defaultResponseMime = "application/json; charset=utf-8"
 )
 
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	log "github.com/cihub/seelog"
 
 	"github.com/HailoOSS/api-proxy/controlplane"
+	"github.com/HailoOSS/api-proxy/stats"
 	"github.com/HailoOSS/platform/util"
 )
 
@@ -20,6 +22,9 @@ const (
 	h2_failure           = "handler.h2.failure"
 	throttle             = "handler.throttle"
 	deprecate            = "handler.deprecate"
+	redirect             = "handler.redirect"
+	mock                 = "handler.mock"
+	upgrade              = "handler.upgrade"
 	h2_azSuccessTemplate = "handler.per-az.%s.h2.success"
 	h2_azFailureTemplate = "handler.per-az.%s.h2.failure"
 )
@@ -77,42 +82,97 @@ func regionPinning(router controlplane.Router, rw http.ResponseWriter) {
 	}
 }
 
+// statusResponseWriter remembers the status written, so we can tell if a request failed once it's been handled
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rw *statusResponseWriter) WriteHeader(status int) {
+	rw.ResponseWriter.WriteHeader(status)
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *statusResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// isError tests if the result of serving was a 5xx error indicating something is wrong
+func (rw *statusResponseWriter) isError() bool {
+	return rw.status >= 500 && rw.status < 600
+}
+
 // Handler will handle an HTTP request, deciding what to do with it
 func Handler(srv *HailoServer) http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
 		router := srv.Control.Router(r)
 		route := router.Route()
 
+		rw := &statusResponseWriter{ResponseWriter: w}
+		ruleId := controlplane.RuleId(route)
+		defer func() {
+			// rule IDs change with the rules, so these are kept in stats (which forgets old rules) rather than
+			// instrumented, which would leave a metric behind for every rule we've ever had
+			stats.RecordRule(ruleId, !rw.isError(), time.Since(start))
+		}()
+
 		maybePinRequestToHostname(router, rw)
 
 		if hobMode := router.GetHobMode(); len(hobMode) > 0 {
 			rw.Header().Set("X-H-Mode", hobMode)
 		}
 
+		rw.Header().Set("X-Hailo-Rule", ruleId)
+
 		if route == nil {
 			log.Tracef("[Handler] No route available; defaulting to H2")
 			rw.Header().Set("X-Hailo-Route", controlplane.ActionSendToH2.String())
-			h2Handler(rw, r, router)
+			h2Handler(rw, r, router, ruleId)
 			return
 		}
 
 		rw.Header().Set("X-Hailo-Route", route.Action.String())
+		if route.Rewrite != nil {
+			route.Rewrite.Apply(r)
+		}
 		switch route.Action {
 		case controlplane.ActionProxyToH1:
 			log.Trace("[Handler] Matched H1 proxy route")
-			h1Handler(rw, r)
+			if route.Mirror != nil && route.Mirror.Sample() {
+				h1MirrorHandler(rw, r, route, ruleId, router)
+			} else {
+				h1Handler(rw, r)
+			}
 		case controlplane.ActionThrottle:
 			log.Trace("[Handler] Matched throttle route")
 			throttleHandler(rw, r, route)
 		case controlplane.ActionDeprecate:
 			log.Trace("[Handler] Matched deprecate route")
 			deprecateHandler(rw, r, route)
+		case controlplane.ActionProxyToUpstream:
+			log.Tracef("[Handler] Matched upstream proxy route to %s", route.Upstream)
+			upstreamHandler(rw, r, route, srv.Control)
+		case controlplane.ActionMock:
+			log.Trace("[Handler] Matched mock route")
+			mockHandler(rw, r, route, router)
+		case controlplane.ActionUpgrade:
+			log.Trace("[Handler] Matched upgrade route")
+			upgradeHandler(rw, r, route)
+		case controlplane.ActionRedirect:
+			log.Trace("[Handler] Matched redirect route")
+			redirectHandler(rw, r, route, router)
 		case controlplane.ActionSendToH2:
 			log.Trace("[Handler] Matched H2 route")
-			h2Handler(rw, r, router)
+			h2Handler(rw, r, router, ruleId)
 		default:
 			log.Errorf("[Handler] Unknown route action %v", route.Action)
-			h2Handler(rw, r, router)
+			h2Handler(rw, r, router, ruleId)
 		}
 	}
 }
@@ -8,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/HailoOSS/api-proxy/controlplane"
+	"github.com/HailoOSS/api-proxy/stats"
 	ptesting "github.com/HailoOSS/platform/testing"
 	"github.com/HailoOSS/service/config"
 )
@@ -285,3 +287,106 @@ func (suite *HandlerSuite) TestXHailoRoute() {
 	usedRoute = resp.Header.Get("X-Hailo-Route")
 	suite.Assertions.Equal("Throttle", usedRoute)
 }
+
+func (suite *HandlerSuite) TestXHailoRuleAndRuleStats() {
+	configJson := `{
+		"controlplane": {
+			"configVersion": 10001,
+			"rules": {
+				"test-throttle": {
+					"match": {
+						"path": "/throttle",
+						"proportion": 1.0
+					},
+					"action": 3
+				}
+			},
+			"regions": {
+				"eu-west-1": {
+					"id": "eu-west-1",
+					"status": "ONLINE",
+					"apps": {
+						"default": {
+							"api": "api-driver-london.elasticride.com"
+						}
+					}
+				}
+			}
+		}
+	}`
+	buf := bytes.NewBufferString(configJson)
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	config.Load(buf)
+	time.Sleep(time.Second)
+
+	server, client := suite.server, suite.client
+	ruleId := suite.server.Control.Rules()[0].Id()
+	before := stats.GetRuleStat(ruleId).Hits
+
+	// A request matching the throttle rule
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/throttle", server.URL), nil)
+	suite.Assertions.NoError(err)
+	resp, err := client.Do(req)
+	suite.Assertions.NoError(err)
+	suite.Assertions.Equal(ruleId, resp.Header.Get("X-Hailo-Rule"))
+	time.Sleep(100 * time.Millisecond) // stats are recorded once the response has been written
+	suite.Assertions.Equal(before+1, stats.GetRuleStat(ruleId).Hits)
+
+	// Forced routes aren't attributed to the rule they would have matched
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/throttle", server.URL), nil)
+	req.Header.Set("X-Hailo-Route", "Deprecate")
+	resp, err = client.Do(req)
+	suite.Assertions.NoError(err)
+	suite.Assertions.Equal(controlplane.ForcedRuleId, resp.Header.Get("X-Hailo-Rule"))
+	time.Sleep(100 * time.Millisecond)
+	suite.Assertions.Equal(before+1, stats.GetRuleStat(ruleId).Hits)
+}
+
+func (suite *HandlerSuite) TestRedirect() {
+	configJson := `{
+		"controlplane": {
+			"configVersion": 10001,
+			"rules": {
+				"test-redirect": {
+					"match": {
+						"path": "/v1/old",
+						"proportion": 1.0
+					},
+					"action": 6,
+					"redirect": {
+						"target": "https://api.example.com/v2{path}?{query}",
+						"status": 308
+					}
+				}
+			},
+			"regions": {
+				"eu-west-1": {
+					"id": "eu-west-1",
+					"status": "ONLINE",
+					"apps": {
+						"default": {
+							"api": "api-driver-london.elasticride.com"
+						}
+					}
+				}
+			}
+		}
+	}`
+	buf := bytes.NewBufferString(configJson)
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	config.Load(buf)
+	time.Sleep(time.Second)
+
+	server, client := suite.server, suite.client
+
+	// We don't want to follow the redirect, just check we were given it
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/old/thing?foo=bar", server.URL), nil)
+	suite.Assertions.NoError(err)
+	resp, err := client.Transport.RoundTrip(req)
+	suite.Assertions.NoError(err)
+	suite.Assertions.Equal(308, resp.StatusCode)
+	suite.Assertions.Equal("Redirect", resp.Header.Get("X-Hailo-Route"))
+	suite.Assertions.Equal("https://api.example.com/v2/v1/old/thing?foo=bar", resp.Header.Get("Location"))
+}
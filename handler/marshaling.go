@@ -1,12 +1,20 @@
 package handler
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strings"
+	"unicode/utf8"
 
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/api-proxy/jsonbody"
 	api "github.com/HailoOSS/api-proxy/proto/api"
 	"github.com/HailoOSS/platform/errors"
 	"github.com/HailoOSS/protobuf/proto"
@@ -23,29 +31,57 @@ var (
 	passHeaders = map[string]bool{"Authorization": true}
 )
 
-func httpRequestToProto(r *http.Request) (*api.Request, errors.Error) {
+// httpRequestToProto maps an HTTP request to proto for an API-tier service, with metadata about the client and how we
+// routed it (see requestMetadata)
+func httpRequestToProto(r *http.Request, md *api.Request_Metadata) (*api.Request, errors.Error) {
 	protoReq := &api.Request{
 		Path:   proto.String(r.URL.Path),
 		Verb:   proto.String(r.Method),
 		Get:    make([]*api.Request_Pair, 0),
 		Post:   make([]*api.Request_Pair, 0),
 		Header: make([]string, 0),
+		// We always write rawBody if a service gives us one
+		AcceptRawBody: proto.Bool(true),
+		Metadata:      md,
 	}
 
 	// Not all clients send the correct mime type. Add it if it's missing
 	var ct string
+	var ctParams map[string]string
 	if r.Method == "POST" || r.Method == "PUT" {
 		var err error
-		ct, _, err = mime.ParseMediaType(r.Header.Get("Content-Type"))
+		ct, ctParams, err = mime.ParseMediaType(r.Header.Get("Content-Type"))
 		if ct == "" || err != nil {
 			ct = formEncodedMime
 			r.Header.Set("Content-Type", ct)
 		}
 	}
 
+	hobCode := ""
+
+	// JSON bodies are passed on as they are, but we pass their values on as for form posts too (which leaves the body
+	// intact)
+	var jsonValues url.Values
+	if r.Body != nil && jsonbody.IsJson(r) {
+		jsonValues = jsonbody.Values(r)
+	}
+
+	// The body always goes as a string, as it always has, so existing services keep working. Services that know
+	// about them can use the bytes of binary bodies (which get mangled as strings) or the parts of multipart ones
 	if r.Body != nil && ct != formEncodedMime {
 		reqBytes, _ := ioutil.ReadAll(r.Body)
 		protoReq.Body = proto.String(string(reqBytes))
+		if !utf8.Valid(reqBytes) {
+			protoReq.RawBody = reqBytes
+		}
+		if ct == multipartMime {
+			// a body we can't split is still passed on as it is, as it always was
+			if parts, err := multipartToProto(reqBytes, ctParams["boundary"]); err != nil {
+				log.Debugf("[Marshaling] Passing on multipart body we can't parse as it is: %v", err)
+			} else {
+				protoReq.Part = parts
+			}
+		}
 	}
 
 	// Get GET, POST or PUT parameters
@@ -59,51 +95,29 @@ func httpRequestToProto(r *http.Request) (*api.Request, errors.Error) {
 		}
 	}
 
-	hobCode := ""
-
-	for k, vs := range r.URL.Query() {
-		if k == sessionId || k == apiToken {
-			continue
-		}
-
-		switch k {
-		case hob:
-			hobCode = vs[0]
-			continue
-		case city:
-			if len(vs[0]) == 3 && len(hobCode) != 3 {
-				hobCode = vs[0]
+	addPairs := func(pairs []*api.Request_Pair, values url.Values) []*api.Request_Pair {
+		for k, vs := range values {
+			if k == sessionId || k == apiToken {
+				continue
 			}
-		}
-
-		// pick first v
-		protoReq.Get = append(protoReq.Get, &api.Request_Pair{
-			Key:   proto.String(k),
-			Value: proto.String(vs[0]),
-		})
-	}
-
-	for k, vs := range r.PostForm {
-		if k == sessionId || k == apiToken {
-			continue
-		}
 
-		switch k {
-		case hob:
-			hobCode = vs[0]
-			continue
-		case city:
-			if len(vs[0]) == 3 && len(hobCode) != 3 {
+			switch k {
+			case hob:
 				hobCode = vs[0]
+				continue
+			case city:
+				if len(vs[0]) == 3 && len(hobCode) != 3 {
+					hobCode = vs[0]
+				}
 			}
-		}
 
-		// pick first v
-		protoReq.Post = append(protoReq.Post, &api.Request_Pair{
-			Key:   proto.String(k),
-			Value: proto.String(vs[0]),
-		})
+			pairs = append(pairs, pairToProto(k, vs))
+		}
+		return pairs
 	}
+	protoReq.Get = addPairs(protoReq.Get, r.URL.Query())
+	protoReq.Post = addPairs(protoReq.Post, r.PostForm)
+	protoReq.Post = addPairs(protoReq.Post, jsonValues)
 
 	// need to add HOB back in
 	if len(hobCode) == 3 {
@@ -129,3 +143,70 @@ func httpRequestToProto(r *http.Request) (*api.Request, errors.Error) {
 
 	return protoReq, nil
 }
+
+// pairToProto maps a parameter to a proto pair: its first value, and every value if there's more than one
+func pairToProto(k string, vs []string) *api.Request_Pair {
+	pair := &api.Request_Pair{
+		Key:   proto.String(k),
+		Value: proto.String(vs[0]),
+	}
+	if len(vs) > 1 {
+		pair.Values = vs
+	}
+	return pair
+}
+
+// setGetPair sets a GET param of a proto request, replacing any the client sent
+func setGetPair(protoReq *api.Request, k, v string) {
+	get := protoReq.Get[:0]
+	for _, pair := range protoReq.Get {
+		if pair.GetKey() != k {
+			get = append(get, pair)
+		}
+	}
+	protoReq.Get = append(get, pairToProto(k, []string{v}))
+}
+
+// multipartToProto splits a multipart/form-data body into proto parts, with their headers and (decoded) bodies
+func multipartToProto(body []byte, boundary string) ([]*api.Request_Part, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("missing boundary")
+	}
+
+	parts := make([]*api.Request_Part, 0)
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		partBody, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		part := &api.Request_Part{
+			Name:   proto.String(p.FormName()),
+			Header: make([]string, 0, len(p.Header)),
+			Body:   partBody,
+		}
+		if filename := p.FileName(); filename != "" {
+			part.Filename = proto.String(filename)
+		}
+		for k, v := range p.Header {
+			part.Header = append(part.Header, fmt.Sprintf("%s: %s", k, strings.Join(v, ",")))
+		}
+		parts = append(parts, part)
+	}
+}
+
+// responseBody is the body of an H2 response: its raw body if it has one, otherwise its (string) body
+func responseBody(rsp *api.Response) []byte {
+	if rsp.GetRawBody() != nil {
+		return rsp.GetRawBody()
+	}
+	return []byte(rsp.GetBody())
+}
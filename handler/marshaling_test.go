@@ -2,11 +2,14 @@ package handler
 
 import (
 	"bytes"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
 	api "github.com/HailoOSS/api-proxy/proto/api"
+	"github.com/HailoOSS/protobuf/proto"
 )
 
 func TestHttpFormEncodedPostReqToProto(t *testing.T) {
@@ -17,7 +20,7 @@ func TestHttpFormEncodedPostReqToProto(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", "http://localhost", bytes.NewReader([]byte(body)))
 
-	apiReq, err := httpRequestToProto(req)
+	apiReq, err := httpRequestToProto(req, nil)
 	if err != nil {
 		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
 	}
@@ -37,7 +40,7 @@ func TestHttpFormEncodedPostReqToProtoWithQuery(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", "http://localhost?barbar=foobarbazbing", bytes.NewReader([]byte(body)))
 
-	apiReq, err := httpRequestToProto(req)
+	apiReq, err := httpRequestToProto(req, nil)
 	if err != nil {
 		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
 	}
@@ -61,7 +64,7 @@ func TestHttpFormEncodedPostReqToProtoWithQueryAndBasicAuth(t *testing.T) {
 	req.SetBasicAuth("Aladdin", "open sesame")
 	req.Header.Add("Something-Silly", "golang")
 
-	apiReq, err := httpRequestToProto(req)
+	apiReq, err := httpRequestToProto(req, nil)
 	if err != nil {
 		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
 	}
@@ -80,14 +83,14 @@ func TestHttpFormEncodedPostReqToProtoWithQueryAndBasicAuth(t *testing.T) {
 
 func TestHttpGetIgnoredSessionIdAndApiToken(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://localhost?session_id=foobarbazbing", nil)
-	apiReq, err := httpRequestToProto(req)
+	apiReq, err := httpRequestToProto(req, nil)
 	if err != nil {
 		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
 	}
 	assertKVs(t, apiReq.GetGet(), map[string]string{})
 
 	req, _ = http.NewRequest("GET", "http://localhost?api_token=foobarbazbing", nil)
-	apiReq, err = httpRequestToProto(req)
+	apiReq, err = httpRequestToProto(req, nil)
 	if err != nil {
 		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
 	}
@@ -99,18 +102,172 @@ func TestHttpJsonPostReqToProto(t *testing.T) {
 	req, _ := http.NewRequest("POST", "http://localhost", bytes.NewReader([]byte(json)))
 	req.Header.Set("Content-Type", "application/json")
 
-	apiReq, err := httpRequestToProto(req)
+	apiReq, err := httpRequestToProto(req, nil)
 	if err != nil {
 		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
 	}
 
-	assertKVs(t, apiReq.GetPost(), map[string]string{})
+	// values are passed on as for form posts, as well as the body
+	assertKVs(t, apiReq.GetPost(), map[string]string{"foo": "bar"})
 	assertKVs(t, apiReq.GetGet(), map[string]string{})
 	if apiReq.GetBody() != json {
 		t.Errorf("Expecting JSON body %s - got %s", json, apiReq.GetBody())
 	}
 }
 
+func TestHttpJsonPostWithCityReqToProto(t *testing.T) {
+	json := `{"city":"LON","customer":123,"session_id":"foobarbazbing"}`
+	req, _ := http.NewRequest("POST", "http://localhost", bytes.NewReader([]byte(json)))
+	req.Header.Set("Content-Type", "application/json")
+
+	apiReq, err := httpRequestToProto(req, nil)
+	if err != nil {
+		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
+	}
+
+	// The HOB is added like it is for any other request, as are the routing values (but not the session, which H2
+	// gets from session.SessionId), and the body is passed on as it is
+	assertKVs(t, apiReq.GetPost(), map[string]string{"hob": "LON", "city": "LON", "customer": "123"})
+	assertKVs(t, apiReq.GetGet(), map[string]string{"hob": "LON"})
+	if apiReq.GetBody() != json {
+		t.Errorf("Expecting JSON body %s - got %s", json, apiReq.GetBody())
+	}
+}
+
+func TestHttpMultiValuedReqToProto(t *testing.T) {
+	body := "foo=bar&foo=baz&bing=bong"
+	req, _ := http.NewRequest("POST", "http://localhost?a=1&a=2&b=3", bytes.NewReader([]byte(body)))
+
+	apiReq, err := httpRequestToProto(req, nil)
+	if err != nil {
+		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
+	}
+
+	// value is still the first, for services that only know about that
+	assertKVs(t, apiReq.GetGet(), map[string]string{"a": "1", "b": "3"})
+	assertKVs(t, apiReq.GetPost(), map[string]string{"foo": "bar", "bing": "bong"})
+	assertValues(t, apiReq.GetGet(), map[string][]string{"a": {"1", "2"}, "b": nil})
+	assertValues(t, apiReq.GetPost(), map[string][]string{"foo": {"bar", "baz"}, "bing": nil})
+}
+
+func TestHttpBinaryPostReqToProto(t *testing.T) {
+	body := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	req, _ := http.NewRequest("POST", "http://localhost", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "image/png")
+
+	apiReq, err := httpRequestToProto(req, nil)
+	if err != nil {
+		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
+	}
+
+	if !bytes.Equal(apiReq.GetRawBody(), body) {
+		t.Errorf("Expecting raw body %v - got %v", body, apiReq.GetRawBody())
+	}
+	// existing services still get the body as they always have
+	if apiReq.GetBody() != string(body) {
+		t.Errorf("Expecting body %q - got %q", body, apiReq.GetBody())
+	}
+	if !apiReq.GetAcceptRawBody() {
+		t.Errorf("Expecting the request to accept raw response bodies")
+	}
+
+	// Text bodies don't need the bytes
+	req, _ = http.NewRequest("POST", "http://localhost", bytes.NewReader([]byte(`{"foo":"bar"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	apiReq, err = httpRequestToProto(req, nil)
+	if err != nil {
+		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
+	}
+	if apiReq.GetRawBody() != nil {
+		t.Errorf("Expecting no raw body - got %v", apiReq.GetRawBody())
+	}
+	if apiReq.GetBody() != `{"foo":"bar"}` {
+		t.Errorf("Expecting the body as a string - got %q", apiReq.GetBody())
+	}
+}
+
+func TestHttpMultipartPostReqToProto(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	w.WriteField("caption", "my car")
+	fw, _ := w.CreateFormFile("photo", "car.png")
+	fw.Write([]byte{0x89, 'P', 'N', 'G', 0x00, 0xff})
+	w.Close()
+
+	req, _ := http.NewRequest("POST", "http://localhost", bytes.NewReader(buf.Bytes()))
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	apiReq, err := httpRequestToProto(req, nil)
+	if err != nil {
+		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
+	}
+
+	if apiReq.GetBody() != buf.String() {
+		t.Errorf("Expecting body %q - got %q", buf.String(), apiReq.GetBody())
+	}
+	parts := apiReq.GetPart()
+	if len(parts) != 2 {
+		t.Fatalf("Expecting 2 parts - got %d", len(parts))
+	}
+	if parts[0].GetName() != "caption" || parts[0].GetFilename() != "" || string(parts[0].GetBody()) != "my car" {
+		t.Errorf("Unexpected first part %v", parts[0])
+	}
+	if parts[1].GetName() != "photo" || parts[1].GetFilename() != "car.png" ||
+		!bytes.Equal(parts[1].GetBody(), []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}) {
+		t.Errorf("Unexpected second part %v", parts[1])
+	}
+	assertHeaders(t, parts[1].GetHeader(), []string{
+		`Content-Disposition: form-data; name="photo"; filename="car.png"`,
+		"Content-Type: application/octet-stream",
+	})
+
+	// A multipart body we can't split (here, without a boundary) is passed on as it is
+	req, _ = http.NewRequest("POST", "http://localhost", bytes.NewReader(buf.Bytes()))
+	req.Header.Set("Content-Type", "multipart/form-data")
+	apiReq, err = httpRequestToProto(req, nil)
+	if err != nil {
+		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
+	}
+	if apiReq.GetBody() != buf.String() || len(apiReq.GetPart()) != 0 {
+		t.Errorf("Expecting the body as it is, without parts - got %q, %v", apiReq.GetBody(), apiReq.GetPart())
+	}
+}
+
+func TestSetGetPair(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/v1/order/123?id=456&foo=bar", nil)
+	apiReq, err := httpRequestToProto(req, nil)
+	if err != nil {
+		t.Fatalf("Unexpected HTTP -> proto marshaling error: %v", err)
+	}
+
+	// params from the path replace those the client sent
+	setGetPair(apiReq, "id", "123")
+	setGetPair(apiReq, "driver", "789")
+	assertKVs(t, apiReq.GetGet(), map[string]string{"id": "123", "foo": "bar", "driver": "789"})
+}
+
+func TestResponseBody(t *testing.T) {
+	rsp := &api.Response{StatusCode: proto.Int32(200), Body: proto.String("foo")}
+	if b := responseBody(rsp); string(b) != "foo" {
+		t.Errorf("Expecting body foo - got %s", b)
+	}
+
+	rsp.RawBody = []byte{0x00, 0xff}
+	if b := responseBody(rsp); !bytes.Equal(b, rsp.RawBody) {
+		t.Errorf("Expecting raw body %v - got %v", rsp.RawBody, b)
+	}
+}
+
+func assertValues(t *testing.T, values []*api.Request_Pair, expected map[string][]string) {
+	for k, vs := range expected {
+		for _, val := range values {
+			if val.GetKey() == k && strings.Join(val.GetValues(), ",") != strings.Join(vs, ",") {
+				t.Errorf("Expecting %s to have values %v - got %v", k, vs, val.GetValues())
+			}
+		}
+	}
+}
+
 func assertKVs(t *testing.T, values []*api.Request_Pair, expected map[string]string) {
 	if len(values) != len(expected) {
 		t.Errorf("Expecting %v K/V values, got %v", len(expected), len(values))
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	gouuid "github.com/nu7hatch/gouuid"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	api "github.com/HailoOSS/api-proxy/proto/api"
+	"github.com/HailoOSS/protobuf/proto"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionSSL30: "SSL3.0",
+	tls.VersionTLS10: "TLS1.0",
+	tls.VersionTLS11: "TLS1.1",
+	tls.VersionTLS12: "TLS1.2",
+}
+
+// requestMetadata is what we know about the client of a request, and (given a router) how we routed it, so H2
+// services don't each have to work it out again
+func requestMetadata(r *http.Request, router controlplane.Router, ruleId string) *api.Request_Metadata {
+	md := &api.Request_Metadata{
+		RequestId: proto.String(requestId(r)),
+		Tls:       proto.Bool(r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"),
+		Host:      proto.String(r.Host),
+	}
+
+	// RealIPHandler has already replaced the address of any load balancer with that of the client
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr // no port
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		md.ClientIp = proto.String(ip.String())
+	}
+	if r.TLS != nil {
+		if v, ok := tlsVersions[r.TLS.Version]; ok {
+			md.TlsVersion = proto.String(v)
+		}
+	}
+
+	if router == nil {
+		return md
+	}
+	md.Hob = proto.String(router.Hob())
+	md.Source = proto.String(router.Source())
+	if region, _ := router.Region(); region != nil {
+		md.Region = proto.String(region.Id)
+	}
+	if ruleId != "" {
+		md.Rule = proto.String(ruleId)
+	}
+	return md
+}
+
+// requestId gets the ID of a request from its X-Request-Id header, or makes one up
+func requestId(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	if u4, err := gouuid.NewV4(); err == nil {
+		return u4.String()
+	}
+	return ""
+}
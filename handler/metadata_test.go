@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+)
+
+func TestRequestMetadata(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://api-driver-london.elasticride.com/v1/point/batch?city=LON", nil)
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	r.Header.Set("X-Request-Id", "abc")
+
+	md := requestMetadata(r, (&controlplane.ControlPlane{}).Router(r), "foo")
+	assert.Equal(t, "2001:db8::1", md.GetClientIp())
+	assert.Equal(t, "abc", md.GetRequestId())
+	assert.False(t, md.GetTls())
+	assert.Equal(t, "", md.GetTlsVersion())
+	assert.Equal(t, "api-driver-london.elasticride.com", md.GetHost())
+	assert.Equal(t, "LON", md.GetHob())
+	assert.Equal(t, "driver", md.GetSource())
+	assert.Equal(t, "foo", md.GetRule())
+	// there aren't any regions in config
+	assert.Nil(t, md.Region)
+}
+
+func TestRequestMetadataWithoutRouter(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://localhost/v1/point/batch?city=LON", nil)
+	r.RemoteAddr = "10.0.0.1"
+	r.TLS = &tls.ConnectionState{Version: tls.VersionTLS12}
+
+	md := requestMetadata(r, nil, "foo")
+	assert.Equal(t, "10.0.0.1", md.GetClientIp())
+	assert.NotEqual(t, "", md.GetRequestId(), "Expected a request ID to be made up")
+	assert.True(t, md.GetTls())
+	assert.Equal(t, "TLS1.2", md.GetTlsVersion())
+	assert.Nil(t, md.Hob)
+	assert.Nil(t, md.Source)
+	assert.Nil(t, md.Rule)
+
+	// TLS terminated by a load balancer
+	r, _ = http.NewRequest("GET", "http://localhost/v1/point/batch", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	md = requestMetadata(r, nil, "")
+	assert.True(t, md.GetTls())
+	assert.Equal(t, "", md.GetTlsVersion())
+	assert.Nil(t, md.ClientIp)
+}
@@ -0,0 +1,366 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	h2error "github.com/HailoOSS/api-proxy/errors"
+	"github.com/HailoOSS/service/config"
+	inst "github.com/HailoOSS/service/instrumentation"
+)
+
+const (
+	mirror_dropped          = "handler.mirror.dropped"
+	mirror_matchTemplate    = "handler.mirror.%s.match"
+	mirror_mismatchTemplate = "handler.mirror.%s.mismatch"
+	mirror_skippedTemplate  = "handler.mirror.%s.skipped"
+
+	// maxMirrorsInFlight is how many mirrored requests we'll wait on H2 for at once; beyond this we drop them
+	maxMirrorsInFlight = 100
+	// maxMirrorBody is the most response body we'll keep to compare; we don't compare bigger responses
+	maxMirrorBody = 1 << 20
+	// defaultMaxMirrorRequestBody is the most request body we'll copy to mirror, if not configured; we don't mirror
+	// requests with bigger bodies
+	defaultMaxMirrorRequestBody = 64 * 1024
+	// maxMirrorDifferences is the most differing fields we'll log for one response
+	maxMirrorDifferences = 20
+	// defaultMirrorLogChance is the proportion of mismatches we log, if not configured
+	defaultMirrorLogChance = 0.01
+)
+
+var (
+	// mirrorSlots limits how many mirrored requests are in flight
+	mirrorSlots = make(chan struct{}, maxMirrorsInFlight)
+	// mirrorDispatch sends a mirrored request to H2 (tests replace this)
+	mirrorDispatch = sendH2
+
+	mirrorLogLock sync.Mutex
+	mirrorLog     io.Writer = ioutil.Discard
+	mirrorLogFile string
+)
+
+// SetMirrorLog sets where we log sampled differences between H1 and H2 responses to mirrored requests
+func SetMirrorLog(w io.Writer) {
+	mirrorLogLock.Lock()
+	defer mirrorLogLock.Unlock()
+	mirrorLog, mirrorLogFile = w, ""
+}
+
+// SetMirrorLogFile sets a file to log sampled differences to, which isn't opened (or created) until we have one to
+// log, so we don't need it unless we're mirroring
+func SetMirrorLogFile(name string) {
+	mirrorLogLock.Lock()
+	defer mirrorLogLock.Unlock()
+	mirrorLog, mirrorLogFile = nil, name
+}
+
+// writeMirrorLog logs a difference, opening the log file first if need be
+func writeMirrorLog(b []byte) error {
+	mirrorLogLock.Lock()
+	defer mirrorLogLock.Unlock()
+	if mirrorLog == nil {
+		f, err := os.OpenFile(mirrorLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		mirrorLog = f
+	}
+	_, err := mirrorLog.Write(b)
+	return err
+}
+
+// mirrorResponseWriter records the status and (up to maxMirrorBody of) the body written to the client
+type mirrorResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rw *mirrorResponseWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *mirrorResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.body.Len()+len(b) > maxMirrorBody {
+		rw.truncated = true
+	} else {
+		rw.body.Write(b)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// mirroredResponse is a response we compare
+type mirroredResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// mirrorDiff is what we log when H1 and H2 responses differ
+type mirrorDiff struct {
+	Time        time.Time         `json:"time"`
+	Rule        string            `json:"rule"`
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Query       string            `json:"query"`
+	H1          *mirroredResponse `json:"h1"`
+	H2          *mirroredResponse `json:"h2"`
+	Differences []string          `json:"differences"`
+}
+
+// h1MirrorHandler serves a request from H1, as h1Handler, then sends a copy to H2 in the background and compares the
+// responses. The client never waits for H2
+func h1MirrorHandler(rw http.ResponseWriter, r *http.Request, rule *controlplane.Rule, ruleId string,
+	router controlplane.Router) {
+	// copy the request before H1 gets hold of it, since the proxy changes it
+	maxBody := config.AtPath("hailo", "api", "mirror", "maxRequestBytes").AsInt(defaultMaxMirrorRequestBody)
+	mirrorReq, ok := copyRequest(r, maxBody)
+	if !ok {
+		inst.Counter(1.0, fmt.Sprintf(mirror_skippedTemplate, ruleId), 1)
+		h1Handler(rw, r)
+		return
+	}
+	// the router (and the request it wraps) are done with once we return, and the request is changed by H1 before
+	// then, so get what we need from it now
+	route := routeH2(mirrorReq, router, ruleId)
+
+	mirrorRw := &mirrorResponseWriter{ResponseWriter: rw}
+	h1Handler(mirrorRw, r)
+
+	if mirrorRw.truncated {
+		inst.Counter(1.0, fmt.Sprintf(mirror_skippedTemplate, ruleId), 1)
+		return
+	}
+	status := mirrorRw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	encoding := mirrorRw.Header().Get("Content-Encoding")
+	h1Body := mirrorRw.body.Bytes()
+
+	select {
+	case mirrorSlots <- struct{}{}:
+		go func() {
+			defer func() { <-mirrorSlots }()
+			// decoding can wait until we're off the client's time
+			body, ok := decodeMirrorBody(encoding, h1Body)
+			if !ok {
+				inst.Counter(1.0, fmt.Sprintf(mirror_skippedTemplate, ruleId), 1)
+				return
+			}
+			compareMirror(mirrorReq, rule, ruleId, &mirroredResponse{Status: status, Body: string(body)}, route)
+		}()
+	default:
+		inst.Counter(1.0, mirror_dropped, 1)
+	}
+}
+
+// decodeMirrorBody undoes the content encoding of a response body, so we compare what H1 meant rather than the bytes
+// it sent. We only understand gzip; false means we can't compare the body (or it's over maxMirrorBody decoded)
+func decodeMirrorBody(encoding string, body []byte) ([]byte, bool) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, true
+	case "gzip", "x-gzip":
+	default:
+		return nil, false
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	defer gz.Close()
+	decoded, err := ioutil.ReadAll(io.LimitReader(gz, maxMirrorBody+1))
+	if err != nil || len(decoded) > maxMirrorBody {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// copyRequest copies a request so it can be sent again, buffering the body so both copies can read it. False means
+// we couldn't copy the body, or it's over maxBody bytes; either way the original is left as it was for H1
+func copyRequest(r *http.Request, maxBody int) (*http.Request, bool) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, int64(maxBody)+1))
+		// put back what we read in front of whatever we didn't
+		r.Body = &struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil {
+			log.Debugf("[Mirror] Error reading request body to mirror: %v", err)
+			return nil, false
+		}
+		if len(body) > maxBody {
+			log.Debugf("[Mirror] Not mirroring a request body larger than %d bytes", maxBody)
+			return nil, false
+		}
+	}
+
+	c := new(http.Request)
+	*c = *r
+	u := *r.URL
+	c.URL = &u
+	c.Header = make(http.Header, len(r.Header))
+	for k, vs := range r.Header {
+		c.Header[k] = append([]string(nil), vs...)
+	}
+	c.Body = ioutil.NopCloser(bytes.NewReader(body))
+	c.Form, c.PostForm, c.MultipartForm = nil, nil, nil
+	return c, true
+}
+
+// compareMirror sends a mirrored request to H2, comparing its response with the one H1 gave
+func compareMirror(r *http.Request, rule *controlplane.Rule, ruleId string, h1Rsp *mirroredResponse, route *h2Route) {
+	h2Rsp := &mirroredResponse{}
+	rsp, perr := mirrorDispatch(r, nil, route)
+	if perr != nil {
+		rec := httptest.NewRecorder()
+		h2error.Write(rec, perr, "application/json", nil)
+		h2Rsp.Status, h2Rsp.Body = rec.Code, rec.Body.String()
+	} else {
+		h2Rsp.Status, h2Rsp.Body = int(rsp.GetStatusCode()), string(responseBody(rsp))
+	}
+
+	differences := mirrorDifferences(h1Rsp, h2Rsp, rule.Mirror.Ignore)
+	if len(differences) == 0 {
+		inst.Counter(1.0, fmt.Sprintf(mirror_matchTemplate, ruleId), 1)
+		return
+	}
+	inst.Counter(1.0, fmt.Sprintf(mirror_mismatchTemplate, ruleId), 1)
+
+	logChance := config.AtPath("hailo", "api", "mirror", "diffLogPcChance").AsFloat64(defaultMirrorLogChance)
+	if rand.Float64() >= logChance {
+		return
+	}
+	b, err := json.Marshal(&mirrorDiff{
+		Time:        time.Now(),
+		Rule:        ruleId,
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Query:       r.URL.RawQuery,
+		H1:          h1Rsp,
+		H2:          h2Rsp,
+		Differences: differences,
+	})
+	if err != nil {
+		log.Warnf("[Mirror] Failed to marshal diff: %v", err)
+		return
+	}
+	if err := writeMirrorLog(append(b, '\n')); err != nil {
+		log.Warnf("[Mirror] Failed to log diff: %v", err)
+	}
+}
+
+// mirrorDifferences compares two responses, returning what differs: "status", "body" (for bodies that aren't JSON)
+// or the dotted paths of differing JSON fields (ignoring those given)
+func mirrorDifferences(h1Rsp, h2Rsp *mirroredResponse, ignore []string) []string {
+	differences := []string{}
+	if h1Rsp.Status != h2Rsp.Status {
+		differences = append(differences, "status")
+	}
+
+	var h1Body, h2Body interface{}
+	h1Err := json.Unmarshal([]byte(h1Rsp.Body), &h1Body)
+	h2Err := json.Unmarshal([]byte(h2Rsp.Body), &h2Body)
+	if h1Err != nil || h2Err != nil {
+		if strings.TrimSpace(h1Rsp.Body) != strings.TrimSpace(h2Rsp.Body) {
+			differences = append(differences, "body")
+		}
+		return differences
+	}
+
+	for _, field := range ignore {
+		path := strings.Split(field, ".")
+		stripJsonField(h1Body, path)
+		stripJsonField(h2Body, path)
+	}
+	jsonDifferences(h1Body, h2Body, "", &differences)
+	return differences
+}
+
+// stripJsonField removes a field from decoded JSON, applying to every element of any arrays on the way
+func stripJsonField(v interface{}, path []string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(v, path[0])
+		} else {
+			stripJsonField(v[path[0]], path[1:])
+		}
+	case []interface{}:
+		for _, e := range v {
+			stripJsonField(e, path)
+		}
+	}
+}
+
+// jsonDifferences appends the dotted paths at which two decoded JSON values differ
+func jsonDifferences(a, b interface{}, path string, differences *[]string) {
+	if len(*differences) >= maxMirrorDifferences {
+		return
+	}
+	at := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "." + k
+	}
+
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for k := range a {
+				keys = append(keys, k)
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				jsonDifferences(a[k], b[k], at(k), differences)
+			}
+			return
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok && len(a) == len(b) {
+			for i := range a {
+				jsonDifferences(a[i], b[i], at(fmt.Sprintf("%d", i)), differences)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "body"
+		}
+		*differences = append(*differences, path)
+	}
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	api "github.com/HailoOSS/api-proxy/proto/api"
+	"github.com/HailoOSS/api-proxy/trace"
+	"github.com/HailoOSS/platform/errors"
+	"github.com/HailoOSS/protobuf/proto"
+	"github.com/HailoOSS/service/config"
+)
+
+func TestMirrorDifferences(t *testing.T) {
+	rsp := func(status int, body string) *mirroredResponse {
+		return &mirroredResponse{Status: status, Body: body}
+	}
+
+	// JSON is compared by value, not formatting
+	assert.Empty(t, mirrorDifferences(
+		rsp(200, `{"status":true,"payload":{"a":1,"b":[1,2]}}`),
+		rsp(200, `{ "payload": {"b": [1, 2], "a": 1.0}, "status": true }`),
+		nil))
+
+	assert.Equal(t, []string{"status"}, mirrorDifferences(rsp(200, `{}`), rsp(500, `{}`), nil))
+	assert.Equal(t, []string{"payload.a", "payload.c"}, mirrorDifferences(
+		rsp(200, `{"payload":{"a":1,"b":2}}`),
+		rsp(200, `{"payload":{"a":2,"b":2,"c":3}}`),
+		nil))
+	assert.Equal(t, []string{"payload.items.1.id"}, mirrorDifferences(
+		rsp(200, `{"payload":{"items":[{"id":1},{"id":2}]}}`),
+		rsp(200, `{"payload":{"items":[{"id":1},{"id":3}]}}`),
+		nil))
+	assert.Equal(t, []string{"payload.items"}, mirrorDifferences(
+		rsp(200, `{"payload":{"items":[1,2]}}`),
+		rsp(200, `{"payload":{"items":[1]}}`),
+		nil))
+
+	// ignored fields don't count, including within arrays
+	assert.Empty(t, mirrorDifferences(
+		rsp(200, `{"payload":{"eta":5,"items":[{"id":1,"ts":1}]}}`),
+		rsp(200, `{"payload":{"eta":6,"items":[{"id":1,"ts":2}]}}`),
+		[]string{"payload.eta", "payload.items.ts"}))
+
+	// bodies that aren't JSON are compared as they are
+	assert.Empty(t, mirrorDifferences(rsp(200, "pong\n"), rsp(200, "pong"), nil))
+	assert.Equal(t, []string{"body"}, mirrorDifferences(rsp(200, "pong"), rsp(200, `"pong"`), nil))
+}
+
+func TestDecodeMirrorBody(t *testing.T) {
+	b, ok := decodeMirrorBody("", []byte(`{"status":true}`))
+	assert.True(t, ok)
+	assert.Equal(t, `{"status":true}`, string(b))
+
+	gzipped := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzipped)
+	gz.Write([]byte(`{"status":true}`))
+	gz.Close()
+	b, ok = decodeMirrorBody("gzip", gzipped.Bytes())
+	assert.True(t, ok)
+	assert.Equal(t, `{"status":true}`, string(b))
+
+	// we can't compare what we can't decode
+	_, ok = decodeMirrorBody("gzip", []byte(`{"status":true}`))
+	assert.False(t, ok)
+	_, ok = decodeMirrorBody("br", []byte(`{"status":true}`))
+	assert.False(t, ok)
+
+	// or what's too big once decoded
+	gzipped.Reset()
+	gz = gzip.NewWriter(gzipped)
+	gz.Write(make([]byte, maxMirrorBody+1))
+	gz.Close()
+	_, ok = decodeMirrorBody("gzip", gzipped.Bytes())
+	assert.False(t, ok)
+}
+
+func TestMirrorLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror-log")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "mirror_log")
+	SetMirrorLogFile(fn)
+	defer SetMirrorLog(ioutil.Discard)
+
+	// not created until there's something to log
+	_, err = os.Stat(fn)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, writeMirrorLog([]byte("foo\n")))
+	assert.NoError(t, writeMirrorLog([]byte("bar\n")))
+	b, _ := ioutil.ReadFile(fn)
+	assert.Equal(t, "foo\nbar\n", string(b))
+
+	// and if it can't be, we just fail to log
+	SetMirrorLogFile(filepath.Join(dir, "missing", "mirror_log"))
+	assert.Error(t, writeMirrorLog([]byte("foo\n")))
+}
+
+func TestCopyRequest(t *testing.T) {
+	r, _ := http.NewRequest("POST", "http://api.example.com/v1/point?foo=bar", strings.NewReader("a=1"))
+	r.Header.Set("X-H-Foo", "foo")
+
+	c, ok := copyRequest(r, 10)
+	assert.True(t, ok)
+
+	// changing the original (as the H1 proxy does) doesn't change the copy
+	r.URL.Host = "v1-api.example.com"
+	r.Header.Set("X-H-Foo", "bar")
+	assert.Equal(t, "api.example.com", c.URL.Host)
+	assert.Equal(t, "foo", c.Header.Get("X-H-Foo"))
+
+	// and both can read the body
+	b, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, "a=1", string(b))
+	b, _ = ioutil.ReadAll(c.Body)
+	assert.Equal(t, "a=1", string(b))
+
+	// but we don't copy bodies over the limit, leaving them for H1 as they were
+	r, _ = http.NewRequest("POST", "http://api.example.com/v1/point", strings.NewReader("a=1&b=2&c=3"))
+	_, ok = copyRequest(r, 10)
+	assert.False(t, ok)
+	b, _ = ioutil.ReadAll(r.Body)
+	assert.Equal(t, "a=1&b=2&c=3", string(b))
+}
+
+// returnedRouter is a router that mustn't be used once the handler given it has returned
+type returnedRouter struct {
+	controlplane.Router
+	t        *testing.T
+	returned int32
+}
+
+func (r *returnedRouter) check() {
+	if atomic.LoadInt32(&r.returned) != 0 {
+		r.t.Error("Router used after the handler returned")
+	}
+}
+
+func (r *returnedRouter) Hob() string {
+	r.check()
+	return r.Router.Hob()
+}
+
+func (r *returnedRouter) Source() string {
+	r.check()
+	return r.Router.Source()
+}
+
+func (r *returnedRouter) Region() (*controlplane.Region, int64) {
+	r.check()
+	return r.Router.Region()
+}
+
+func (r *returnedRouter) H2Endpoint(path string) (string, string, map[string]string, bool) {
+	r.check()
+	return r.Router.H2Endpoint(path)
+}
+
+func TestH1MirrorHandler(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	config.Load(bytes.NewBufferString(`{"hailo":{"api":{"mirror":{"diffLogPcChance":1}}}}`))
+
+	origProxy, origDispatch := v1Proxy, mirrorDispatch
+	defer func() { v1Proxy, mirrorDispatch = origProxy, origDispatch }()
+	logBuf := &bytes.Buffer{}
+	SetMirrorLog(logBuf)
+	defer SetMirrorLog(ioutil.Discard)
+
+	v1Proxy = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// H1 compresses its response, which we compare decompressed
+		rw.Header().Set("Content-Encoding", "gzip")
+		rw.WriteHeader(http.StatusOK)
+		gz := gzip.NewWriter(rw)
+		gz.Write([]byte(`{"status":true,"payload":{"eta":5,"driver":"bob"}}`))
+		gz.Close()
+	})
+	h2Body := make(chan string, 1)
+	h2Routes := make(chan *h2Route, 1)
+	mirrorDispatch = func(r *http.Request, traceInfo *trace.APITraceInfo, route *h2Route) (*api.Response, errors.Error) {
+		b, _ := ioutil.ReadAll(r.Body)
+		h2Body <- string(b)
+		h2Routes <- route
+		return &api.Response{
+			StatusCode: proto.Int32(200),
+			Body:       proto.String(`{"status":true,"payload":{"eta":6,"driver":"alice"}}`),
+		}, nil
+	}
+
+	rule := &controlplane.Rule{
+		Action: controlplane.ActionProxyToH1,
+		Mirror: &controlplane.Mirror{Proportion: 1, Ignore: []string{"payload.eta"}},
+	}
+	r, _ := http.NewRequest("POST", "http://api.example.com/v1/driver/find", strings.NewReader("hob=LON"))
+	rw := httptest.NewRecorder()
+	router := &returnedRouter{Router: (&controlplane.ControlPlane{}).Router(r), t: t}
+	h1MirrorHandler(rw, r, rule, "rule1", router)
+	atomic.StoreInt32(&router.returned, 1)
+
+	// the client gets H1's response
+	assert.Equal(t, 200, rw.Code)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+
+	// and H2 gets a copy of the request in the background
+	select {
+	case b := <-h2Body:
+		assert.Equal(t, "hob=LON", b)
+		// routed as the client's request was, before the handler returned
+		route := <-h2Routes
+		assert.Equal(t, "com.HailoOSS.api.v1.driver", route.service)
+		assert.Equal(t, "find", route.endpoint)
+		assert.Equal(t, "rule1", route.metadata.GetRule())
+	case <-time.After(time.Second):
+		t.Fatal("Expecting the request to be mirrored to H2")
+	}
+
+	// with the difference logged
+	var logged string
+	for i := 0; i < 100 && logged == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+		mirrorLogLock.Lock()
+		logged = logBuf.String()
+		mirrorLogLock.Unlock()
+	}
+	diff := &mirrorDiff{}
+	if assert.NoError(t, json.Unmarshal([]byte(logged), diff)) {
+		assert.Equal(t, "rule1", diff.Rule)
+		assert.Equal(t, "/v1/driver/find", diff.Path)
+		assert.Equal(t, []string{"payload.driver"}, diff.Differences)
+		assert.Contains(t, diff.H1.Body, "bob")
+	}
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	h2error "github.com/HailoOSS/api-proxy/errors"
+	"github.com/HailoOSS/platform/errors"
+	inst "github.com/HailoOSS/service/instrumentation"
+)
+
+// mockHandler is responsible for serving canned responses, in place of a real backend
+func mockHandler(rw http.ResponseWriter, r *http.Request, rule *controlplane.Rule, router controlplane.Router) {
+	// count hits
+	inst.Counter(1.0, mock, 1)
+
+	// the body file couldn't be read when config was loaded (which we logged then)
+	if rule.Mock.Err() != nil {
+		h2error.Write(rw, errors.InternalServerError("com.HailoOSS.api.mock", "Mock response unavailable."),
+			"application/json", nil)
+		return
+	}
+
+	vars := controlplane.MockVars{
+		Hob:       router.Hob(),
+		Path:      r.URL.Path,
+		RequestId: requestId(r),
+	}
+
+	if d := rule.Mock.Delay(); d > 0 {
+		time.Sleep(d)
+	}
+
+	for k, vs := range rule.Mock.RenderHeaders(vars) {
+		for _, v := range vs {
+			rw.Header().Add(k, v)
+		}
+	}
+	rw.WriteHeader(rule.Mock.StatusCode())
+	rw.Write([]byte(rule.Mock.RenderBody(vars)))
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+)
+
+func TestMockHandler(t *testing.T) {
+	rule := &controlplane.Rule{Action: controlplane.ActionMock, Mock: &controlplane.Mock{
+		Body:       `{"status":true,"payload":{"hob":"{{hob}}","id":"{{requestId}}"}}`,
+		HttpStatus: 201,
+		Headers:    map[string]string{"X-H-Mock": "{{path}}"},
+	}}
+	assert.NoError(t, rule.Compile())
+
+	r, _ := http.NewRequest("GET", "http://api.example.com/v1/order/123?city=LON", nil)
+	r.Header.Set("X-Request-Id", "abc")
+	rw := httptest.NewRecorder()
+	mockHandler(rw, r, rule, (&controlplane.ControlPlane{}).Router(r))
+
+	assert.Equal(t, 201, rw.Code)
+	assert.Equal(t, `{"status":true,"payload":{"hob":"LON","id":"abc"}}`, rw.Body.String())
+	assert.Equal(t, "/v1/order/123", rw.Header().Get("X-H-Mock"))
+	assert.Equal(t, "application/json; charset=utf-8", rw.Header().Get("Content-Type"))
+
+	// without a request ID we make one up
+	r, _ = http.NewRequest("GET", "http://api.example.com/v1/order/123?city=LON", nil)
+	rw = httptest.NewRecorder()
+	mockHandler(rw, r, rule, (&controlplane.ControlPlane{}).Router(r))
+	assert.NotContains(t, rw.Body.String(), `"id":""`)
+
+	// mocks whose body file couldn't be read serve an error
+	rule = &controlplane.Rule{Action: controlplane.ActionMock, Mock: &controlplane.Mock{BodyFile: "missing.json"}}
+	assert.NoError(t, rule.Compile())
+	rw = httptest.NewRecorder()
+	mockHandler(rw, r, rule, (&controlplane.ControlPlane{}).Router(r))
+	assert.Equal(t, 500, rw.Code)
+}
@@ -0,0 +1,17 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	inst "github.com/HailoOSS/service/instrumentation"
+)
+
+// redirectHandler is responsible for moving clients elsewhere, by redirecting them to the rule's target
+func redirectHandler(rw http.ResponseWriter, r *http.Request, rule *controlplane.Rule, router controlplane.Router) {
+	// count hits
+	inst.Counter(1.0, redirect, 1)
+
+	location := rule.Redirect.Location(r.URL.EscapedPath(), r.URL.RawQuery, router.Hob())
+	http.Redirect(rw, r, location, rule.Redirect.StatusCode())
+}
@@ -67,6 +67,12 @@ func initServeMux(s *http.ServeMux, srv *HailoServer) {
 	s.HandleFunc("/v2/az/status", srv.Monitor.Handler)
 	s.HandleFunc("/status", statusmonitor.StatusHandler)
 	s.HandleFunc("/endpoints", EndpointsHandler(srv))
+	s.HandleFunc("/admin/explain", ExplainHandler(srv))
+	s.HandleFunc("/admin/rules/stats", RuleStatsHandler(srv))
+	s.HandleFunc("/admin/config/history", ConfigHistoryHandler(srv))
+	s.HandleFunc("/admin/config/lastgood", LastGoodConfigsHandler(srv))
+	s.HandleFunc("/admin/config/pin", PinConfigHandler(srv))
+	s.HandleFunc("/admin/config/unpin", UnpinConfigHandler(srv))
 }
 
 // Creates a new server, with the correct timeouts, throttling, etc.
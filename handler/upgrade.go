@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	inst "github.com/HailoOSS/service/instrumentation"
+)
+
+const (
+	defaultUpgradeMessage = "Upgrade required"
+)
+
+// upgradePayload is our standard error response, plus where to get a new app
+type upgradePayload struct {
+	Status   bool   `json:"status"`
+	Payload  string `json:"payload"`
+	Code     int    `json:"code"`
+	Platform string `json:"platform,omitempty"`
+	StoreUrl string `json:"storeUrl,omitempty"`
+}
+
+// upgradeHandler is responsible for retiring old apps, by telling clients to upgrade and where to get a new app for
+// their platform
+func upgradeHandler(rw http.ResponseWriter, r *http.Request, rule *controlplane.Rule) {
+	// count hits
+	inst.Counter(1.0, upgrade, 1)
+
+	av := controlplane.ParseAppVersion(r.Header.Get, rule.AppVersionHeaders())
+	payload := &upgradePayload{
+		Status:   false,
+		Payload:  rule.Upgrade.Message,
+		Code:     11,
+		Platform: av.Platform,
+		StoreUrl: rule.Upgrade.StoreUrl(av.Platform),
+	}
+	if payload.Payload == "" {
+		payload.Payload = defaultUpgradeMessage
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("[Handler] Error marshaling upgrade payload: %v", err)
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(http.StatusUpgradeRequired)
+	rw.Write(b)
+}
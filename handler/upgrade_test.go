@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+)
+
+func TestUpgradeHandler(t *testing.T) {
+	rule := &controlplane.Rule{
+		Action: controlplane.ActionUpgrade,
+		Match: &controlplane.Match{
+			AppVersion: &controlplane.AppVersionMatch{Version: "< 3.2.0"},
+			Proportion: 1,
+		},
+		Upgrade: &controlplane.Upgrade{StoreUrls: map[string]string{
+			"ios":     "https://itunes.apple.com/app/id1",
+			"default": "https://hailoapp.com",
+		}},
+	}
+	assert.NoError(t, rule.Compile())
+
+	r, _ := http.NewRequest("GET", "http://api.example.com/v1/order", nil)
+	r.Header.Set("User-Agent", "Hailo/3.1.4 (iOS 9.2)")
+	rw := httptest.NewRecorder()
+	upgradeHandler(rw, r, rule)
+
+	assert.Equal(t, http.StatusUpgradeRequired, rw.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"status":false,"payload":"Upgrade required","code":11,"platform":"ios",`+
+		`"storeUrl":"https://itunes.apple.com/app/id1"}`, rw.Body.String())
+
+	// other platforms get the default store URL, and the message can be changed
+	rule.Upgrade.Message = "Please upgrade Hailo"
+	r.Header.Set("User-Agent", "Hailo/3.1.4 (Windows Phone)")
+	rw = httptest.NewRecorder()
+	upgradeHandler(rw, r, rule)
+	assert.JSONEq(t, `{"status":false,"payload":"Please upgrade Hailo","code":11,"platform":"windows",`+
+		`"storeUrl":"https://hailoapp.com"}`, rw.Body.String())
+}
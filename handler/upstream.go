@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	"github.com/HailoOSS/api-proxy/stats"
+	inst "github.com/HailoOSS/service/instrumentation"
+)
+
+const (
+	upstream_successTemplate = "handler.upstream.%s.success"
+	upstream_failureTemplate = "handler.upstream.%s.failure"
+)
+
+var (
+	// upstreamProxies are cached and shared among all requests to the same upstream, indexed by name
+	upstreamProxies     = make(map[string]*upstreamProxy)
+	upstreamProxiesLock sync.Mutex
+)
+
+// upstreamProxy is a reverse proxy to an upstream's servers, which it uses in turn
+type upstreamProxy struct {
+	upstream  *controlplane.Upstream // the config this proxy was made for
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	next      uint32 // next server to use
+}
+
+// proxyForUpstream returns the proxy for an upstream, making a new one if there isn't one or its config has changed
+func proxyForUpstream(name string, u *controlplane.Upstream) *upstreamProxy {
+	upstreamProxiesLock.Lock()
+	defer upstreamProxiesLock.Unlock()
+
+	if p, ok := upstreamProxies[name]; ok {
+		if p.upstream == u {
+			return p
+		}
+		// config reloads give us new upstreams, but we only need a new proxy if this one has changed
+		if reflect.DeepEqual(p.upstream, u) {
+			p.upstream = u
+			return p
+		}
+		p.transport.CloseIdleConnections()
+	}
+	p := newUpstreamProxy(u)
+	upstreamProxies[name] = p
+	return p
+}
+
+func newUpstreamProxy(u *controlplane.Upstream) *upstreamProxy {
+	p := &upstreamProxy{upstream: u}
+
+	dialTimeout := u.DialTimeoutDuration()
+	p.transport = &http.Transport{
+		Dial: func(network, address string) (net.Conn, error) {
+			return net.DialTimeout(network, address, dialTimeout)
+		},
+		ResponseHeaderTimeout: u.ResponseTimeoutDuration(),
+		MaxIdleConnsPerHost:   u.IdleConns(),
+	}
+	if u.Tls != nil {
+		p.transport.TLSClientConfig = &tls.Config{
+			ServerName:         u.Tls.ServerName,
+			InsecureSkipVerify: u.Tls.InsecureSkipVerify,
+		}
+	}
+
+	targets := u.Targets()
+	director := func(req *http.Request) {
+		target := targets[int(atomic.AddUint32(&p.next, 1)-1)%len(targets)]
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.URL.Path = joinUpstreamPath(target.Path, req.URL.Path)
+		req.URL.RawPath = ""
+		req.Host = target.Host
+		log.Tracef("[Upstream proxy] Proxying request to %s://%s%s", req.URL.Scheme, req.URL.Host, req.URL.Path)
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		Director:  director,
+		Transport: p.transport,
+	}
+	return p
+}
+
+// joinUpstreamPath prefixes a request path with the path of an upstream URL, if any
+func joinUpstreamPath(prefix, p string) string {
+	if prefix == "" || prefix == "/" {
+		return p
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+// upstreamHandler is responsible for proxying requests to a named upstream
+func upstreamHandler(rw http.ResponseWriter, r *http.Request, rule *controlplane.Rule, control *controlplane.ControlPlane) {
+	u := control.Upstream(rule.Upstream)
+	if u == nil || len(u.Targets()) == 0 {
+		// the control plane checks rules refer to upstreams that exist, so this shouldn't happen
+		log.Errorf("[Handler] Unknown upstream %s", rule.Upstream)
+		rw.WriteHeader(http.StatusBadGateway)
+		rw.Write(proxyErrorPayload)
+		return
+	}
+
+	start := time.Now()
+	proxyRw := &h1ProxyResponseWriter{
+		ResponseWriter: rw,
+	}
+
+	defer func() {
+		key := fmt.Sprintf(upstream_successTemplate, sanitizeKey(rule.Upstream))
+		if proxyRw.isError() {
+			key = fmt.Sprintf(upstream_failureTemplate, sanitizeKey(rule.Upstream))
+		}
+		inst.Timing(1.0, key, time.Since(start))
+		inst.Counter(1.0, key, 1)
+
+		if r.URL.Path != "/" {
+			stats.Record("/", !proxyRw.isError(), time.Since(start))
+		}
+		stats.Record(r.URL.Path, !proxyRw.isError(), time.Since(start))
+	}()
+
+	proxyForUpstream(rule.Upstream, u).proxy.ServeHTTP(proxyRw, r)
+
+	if proxyRw.isError() && proxyRw.written == 0 {
+		// most likely a proxy error, so write a response the client will understand
+		if _, err := proxyRw.Write(proxyErrorPayload); err != nil {
+			log.Errorf("Error writing proxy error payload: %v", err)
+		}
+	}
+}
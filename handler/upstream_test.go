@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+	"github.com/HailoOSS/service/config"
+)
+
+func TestUpstreamHandler(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Access-Control-Allow-Origin", "*")
+			fmt.Fprintf(rw, "%s %s %s %s", name, r.Host, r.URL.Path, r.Header.Get("X-Forwarded-Host"))
+		}))
+	}
+	one, two := backend("one"), backend("two")
+	defer one.Close()
+	defer two.Close()
+
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	config.Load(bytes.NewBufferString(`{"controlPlane":{
+		"regions":{"eu-west-1":{"id":"eu-west-1"}},
+		"rules":{"a":{"action":7,"upstream":"orders","match":{"path":"/v1/order","proportion":1}}},
+		"upstreams":{"orders":{"urls":["` + one.URL + `","` + strings.TrimPrefix(two.URL, "http://") + `/prefix"],` +
+		`"scheme":"http"}}}}`))
+	cp := controlplane.New()
+	defer cp.Killf("Test over")
+	rule := cp.Rules()[0]
+
+	// we use each server in turn, prefixing paths as configured
+	responses := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "http://api.example.com/v1/order/123", nil)
+		rw := httptest.NewRecorder()
+		upstreamHandler(rw, r, rule, cp)
+		assert.Equal(t, 200, rw.Code)
+		assert.Equal(t, "", rw.Header().Get("Access-Control-Allow-Origin"), "Expecting CORS headers to be filtered")
+		responses[rw.Body.String()] = true
+	}
+	assert.Equal(t, map[string]bool{
+		fmt.Sprintf("one %s /v1/order/123 api.example.com", strings.TrimPrefix(one.URL, "http://")):        true,
+		fmt.Sprintf("two %s /prefix/v1/order/123 api.example.com", strings.TrimPrefix(two.URL, "http://")): true,
+	}, responses)
+
+	// servers which are down give a standard error
+	two.Close()
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", "http://api.example.com/v1/order/123", nil)
+		rw := httptest.NewRecorder()
+		upstreamHandler(rw, r, rule, cp)
+		if rw.Code != 200 {
+			assert.True(t, rw.Code >= 500)
+			b, _ := ioutil.ReadAll(rw.Body)
+			assert.Equal(t, proxyErrorPayload, b)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package jsonbody reads parameters from JSON request bodies, so clients that POST application/json are routed,
+// sampled and authenticated the same as those that POST form-encoded bodies
+package jsonbody
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/service/config"
+)
+
+const (
+	defaultMaxBytes = 64 * 1024
+)
+
+// IsJson tests if a request has a JSON body, by its content type (application/json, or anything +json)
+func IsJson(r *http.Request) bool {
+	ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return ct == "application/json" || (strings.HasPrefix(ct, "application/") && strings.HasSuffix(ct, "+json"))
+}
+
+// Values reads the top level fields of a JSON object body, plus any nested fields named in config at
+// hailo.api.jsonBody.fields (a map of parameter names to dotted paths, like "customer": "customer.id"). Only strings,
+// numbers and booleans are read. Bodies larger than hailo.api.jsonBody.maxBytes (default 64KB) aren't read at all.
+// The body is left intact, and only decoded once however many times we're asked
+func Values(r *http.Request) url.Values {
+	if rb, ok := r.Body.(*restoredBody); ok {
+		return copyValues(rb.values)
+	}
+	values := url.Values{}
+	if r.Body == nil {
+		return values
+	}
+
+	maxBytes := config.AtPath("hailo", "api", "jsonBody", "maxBytes").AsInt(defaultMaxBytes)
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(maxBytes)+1))
+	// put back what we read in front of whatever we didn't, along with the values we find
+	r.Body = &restoredBody{Reader: io.MultiReader(bytes.NewReader(b), r.Body), Closer: r.Body, values: values}
+	if err != nil {
+		log.Debugf("[jsonbody] Error reading request body: %v", err)
+		return values
+	}
+	if len(b) > maxBytes {
+		log.Debugf("[jsonbody] Not reading a JSON body larger than %d bytes", maxBytes)
+		return values
+	}
+
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		log.Debugf("[jsonbody] Request body isn't a JSON object: %v", err)
+		return values
+	}
+
+	for k, v := range obj {
+		if s, ok := scalar(v); ok {
+			values.Set(k, s)
+		}
+	}
+	for name, path := range config.AtPath("hailo", "api", "jsonBody", "fields").AsStringMap() {
+		if s, ok := scalar(lookup(obj, path)); ok {
+			values.Set(name, s)
+		}
+	}
+	return copyValues(values)
+}
+
+// copyValues copies values, so callers can't change those we keep
+func copyValues(values url.Values) url.Values {
+	c := make(url.Values, len(values))
+	for k, vs := range values {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+// lookup finds the value at a dotted path within an object, or nil if there isn't one
+func lookup(obj map[string]interface{}, path string) interface{} {
+	var v interface{} = obj
+	for _, k := range strings.Split(path, ".") {
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = o[k]
+	}
+	return v
+}
+
+// scalar formats a string, number or boolean as a parameter value
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "false", true
+	}
+	return "", false
+}
+
+// restoredBody reads the body we read from followed by the rest of the original, closing the original. It keeps the
+// values we found in it, so we needn't decode it again
+type restoredBody struct {
+	io.Reader
+	io.Closer
+	values url.Values
+}
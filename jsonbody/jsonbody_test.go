@@ -0,0 +1,92 @@
+package jsonbody
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/HailoOSS/service/config"
+)
+
+func jsonRequest(body string) *http.Request {
+	r, _ := http.NewRequest("POST", "/v1/foo/bar", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestIsJson(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		json        bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/vnd.hailo+json", true},
+		{"application/x-www-form-urlencoded", false},
+		{"text/json+plain", false},
+		{"", false},
+	}
+
+	for i, tc := range testCases {
+		r, _ := http.NewRequest("POST", "/v1/foo/bar", nil)
+		r.Header.Set("Content-Type", tc.contentType)
+		if json := IsJson(r); json != tc.json {
+			t.Errorf("Case %d: expected %v for %q, got %v", i, tc.json, tc.contentType, json)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	origConfigBuf := bytes.NewBuffer(config.Raw())
+	defer config.Load(origConfigBuf)
+	config.Load(bytes.NewBufferString(`{"hailo":{"api":{"jsonBody":{
+		"fields":{"customer":"customer.id","missing":"customer.nope.id"},
+		"maxBytes":100
+	}}}}`))
+
+	testCases := []struct {
+		body   string
+		values map[string]string
+	}{
+		{`{"city":"LON","count":12.5,"live":true,"off":false}`,
+			map[string]string{"city": "LON", "count": "12.5", "live": "true", "off": "false"}},
+		{`{"city":null,"list":["LON"],"obj":{"city":"LON"}}`, map[string]string{}},
+		{`{"customer":{"id":1234567890123}}`, map[string]string{"customer": "1234567890123"}},
+		{`["LON"]`, map[string]string{}},
+		{`city=LON`, map[string]string{}},
+		{``, map[string]string{}},
+		{`{"city":"LON","padding":"` + strings.Repeat("x", 100) + `"}`, map[string]string{}},
+	}
+
+	for i, tc := range testCases {
+		r := jsonRequest(tc.body)
+		values := Values(r)
+		if len(values) != len(tc.values) {
+			t.Errorf("Case %d: expected %v, got %v", i, tc.values, values)
+		}
+		for k, v := range tc.values {
+			if values.Get(k) != v {
+				t.Errorf("Case %d: expected %s=%s, got %q", i, k, v, values.Get(k))
+			}
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		if string(b) != tc.body {
+			t.Errorf("Case %d: expected the body to be intact, got %s", i, string(b))
+		}
+	}
+}
+
+func TestValuesDecodedOnce(t *testing.T) {
+	r := jsonRequest(`{"city":"LON"}`)
+	values := Values(r)
+	values.Set("city", "NYC")
+
+	// with the body read, we still have the values we found in it, unchanged by the caller
+	ioutil.ReadAll(r.Body)
+	if city := Values(r).Get("city"); city != "LON" {
+		t.Errorf("Expected the city we decoded before, got %q", city)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 	"time"
 
 	log "github.com/cihub/seelog"
@@ -18,9 +19,15 @@ import (
 
 var (
 	accessLogName string
+	mirrorLogName string
 )
 
 func init() {
+	// Validating config is done offline, so skip everything else
+	if len(os.Args) > 1 && os.Args[1] == validateCommandName {
+		os.Exit(validateCommand(os.Args[2:], os.Stdout))
+	}
+
 	service.Name = "com.HailoOSS.hailo-2-api"
 	service.Description = "Routing layer that handles all inbound client requests, routing them to H1, H2, or " +
 		"throttling them."
@@ -30,13 +37,40 @@ func init() {
 	service.OwnerMobile = "+447584048620"
 	service.OwnerTeam = "h2o"
 
-	// Before we proceed, load the "last known good" config
+	// Before we proceed, load the "last known good" config (which is before we parse flags, so pick out where from)
+	if dir, ok := earlyFlag(os.Args[1:], "lastgooddir"); ok {
+		controlplane.LastGoodDir = dir
+	}
 	controlplane.LoadLastGoodConfig()
 
 	flag.StringVar(&accessLogName, "accesslog", "access_log", "The location where Apache-style logs should be written")
+	flag.StringVar(&mirrorLogName, "mirrorlog", "mirror_log",
+		"The location where differences between H1 and H2 responses to mirrored requests should be written")
+	flag.StringVar(&controlplane.MockDir, "mockdir", controlplane.MockDir,
+		"The directory where mock response body files are kept")
+	flag.StringVar(&controlplane.LastGoodDir, "lastgooddir", controlplane.LastGoodDir,
+		"The directory where generations of last known good config are kept")
+	flag.IntVar(&controlplane.LastGoodGenerations, "lastgoodgenerations", controlplane.LastGoodGenerations,
+		"The number of generations of last known good config to keep")
 	service.Init()
 }
 
+// earlyFlag finds the value of a string flag in args, for the few flags we need before flags are parsed properly
+func earlyFlag(args []string, name string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name && i+1 < len(args) {
+			return args[i+1], true
+		} else if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"="), true
+		}
+	}
+	return "", false
+}
+
 func main() {
 	config.WaitUntilLoaded(2 * time.Second)
 
@@ -47,6 +81,8 @@ func main() {
 	}
 	defer accessLog.Close()
 
+	handler.SetMirrorLogFile(mirrorLogName)
+
 	// Register stats collection
 	stats.Init(service.Name, ServiceVersion, service.InstanceID)
 	stats.Register("/rpc")
@@ -22,13 +22,17 @@ var _ = proto.Marshal
 var _ = math.Inf
 
 type Request struct {
-	Path             *string         `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
-	Verb             *string         `protobuf:"bytes,2,req,name=verb" json:"verb,omitempty"`
-	Get              []*Request_Pair `protobuf:"bytes,3,rep,name=get" json:"get,omitempty"`
-	Post             []*Request_Pair `protobuf:"bytes,4,rep,name=post" json:"post,omitempty"`
-	Header           []string        `protobuf:"bytes,5,rep,name=header" json:"header,omitempty"`
-	Body             *string         `protobuf:"bytes,6,opt,name=body" json:"body,omitempty"`
-	XXX_unrecognized []byte          `json:"-"`
+	Path             *string           `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
+	Verb             *string           `protobuf:"bytes,2,req,name=verb" json:"verb,omitempty"`
+	Get              []*Request_Pair   `protobuf:"bytes,3,rep,name=get" json:"get,omitempty"`
+	Post             []*Request_Pair   `protobuf:"bytes,4,rep,name=post" json:"post,omitempty"`
+	Header           []string          `protobuf:"bytes,5,rep,name=header" json:"header,omitempty"`
+	Body             *string           `protobuf:"bytes,6,opt,name=body" json:"body,omitempty"`
+	RawBody          []byte            `protobuf:"bytes,7,opt,name=rawBody" json:"rawBody,omitempty"`
+	Part             []*Request_Part   `protobuf:"bytes,8,rep,name=part" json:"part,omitempty"`
+	AcceptRawBody    *bool             `protobuf:"varint,9,opt,name=acceptRawBody" json:"acceptRawBody,omitempty"`
+	Metadata         *Request_Metadata `protobuf:"bytes,10,opt,name=metadata" json:"metadata,omitempty"`
+	XXX_unrecognized []byte            `json:"-"`
 }
 
 func (m *Request) Reset()         { *m = Request{} }
@@ -77,10 +81,39 @@ func (m *Request) GetBody() string {
 	return ""
 }
 
+func (m *Request) GetRawBody() []byte {
+	if m != nil {
+		return m.RawBody
+	}
+	return nil
+}
+
+func (m *Request) GetPart() []*Request_Part {
+	if m != nil {
+		return m.Part
+	}
+	return nil
+}
+
+func (m *Request) GetAcceptRawBody() bool {
+	if m != nil && m.AcceptRawBody != nil {
+		return *m.AcceptRawBody
+	}
+	return false
+}
+
+func (m *Request) GetMetadata() *Request_Metadata {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
 type Request_Pair struct {
-	Key              *string `protobuf:"bytes,1,req,name=key" json:"key,omitempty"`
-	Value            *string `protobuf:"bytes,2,req,name=value" json:"value,omitempty"`
-	XXX_unrecognized []byte  `json:"-"`
+	Key              *string  `protobuf:"bytes,1,req,name=key" json:"key,omitempty"`
+	Value            *string  `protobuf:"bytes,2,req,name=value" json:"value,omitempty"`
+	Values           []string `protobuf:"bytes,3,rep,name=values" json:"values,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
 }
 
 func (m *Request_Pair) Reset()         { *m = Request_Pair{} }
@@ -101,10 +134,138 @@ func (m *Request_Pair) GetValue() string {
 	return ""
 }
 
+func (m *Request_Pair) GetValues() []string {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+type Request_Part struct {
+	Name             *string  `protobuf:"bytes,1,req,name=name" json:"name,omitempty"`
+	Filename         *string  `protobuf:"bytes,2,opt,name=filename" json:"filename,omitempty"`
+	Header           []string `protobuf:"bytes,3,rep,name=header" json:"header,omitempty"`
+	Body             []byte   `protobuf:"bytes,4,opt,name=body" json:"body,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *Request_Part) Reset()         { *m = Request_Part{} }
+func (m *Request_Part) String() string { return proto.CompactTextString(m) }
+func (*Request_Part) ProtoMessage()    {}
+
+func (m *Request_Part) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *Request_Part) GetFilename() string {
+	if m != nil && m.Filename != nil {
+		return *m.Filename
+	}
+	return ""
+}
+
+func (m *Request_Part) GetHeader() []string {
+	if m != nil {
+		return m.Header
+	}
+	return nil
+}
+
+func (m *Request_Part) GetBody() []byte {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+type Request_Metadata struct {
+	ClientIp         *string `protobuf:"bytes,1,opt,name=clientIp" json:"clientIp,omitempty"`
+	RequestId        *string `protobuf:"bytes,2,opt,name=requestId" json:"requestId,omitempty"`
+	Tls              *bool   `protobuf:"varint,3,opt,name=tls" json:"tls,omitempty"`
+	TlsVersion       *string `protobuf:"bytes,4,opt,name=tlsVersion" json:"tlsVersion,omitempty"`
+	Host             *string `protobuf:"bytes,5,opt,name=host" json:"host,omitempty"`
+	Hob              *string `protobuf:"bytes,6,opt,name=hob" json:"hob,omitempty"`
+	Source           *string `protobuf:"bytes,7,opt,name=source" json:"source,omitempty"`
+	Region           *string `protobuf:"bytes,8,opt,name=region" json:"region,omitempty"`
+	Rule             *string `protobuf:"bytes,9,opt,name=rule" json:"rule,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Request_Metadata) Reset()         { *m = Request_Metadata{} }
+func (m *Request_Metadata) String() string { return proto.CompactTextString(m) }
+func (*Request_Metadata) ProtoMessage()    {}
+
+func (m *Request_Metadata) GetClientIp() string {
+	if m != nil && m.ClientIp != nil {
+		return *m.ClientIp
+	}
+	return ""
+}
+
+func (m *Request_Metadata) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
+func (m *Request_Metadata) GetTls() bool {
+	if m != nil && m.Tls != nil {
+		return *m.Tls
+	}
+	return false
+}
+
+func (m *Request_Metadata) GetTlsVersion() string {
+	if m != nil && m.TlsVersion != nil {
+		return *m.TlsVersion
+	}
+	return ""
+}
+
+func (m *Request_Metadata) GetHost() string {
+	if m != nil && m.Host != nil {
+		return *m.Host
+	}
+	return ""
+}
+
+func (m *Request_Metadata) GetHob() string {
+	if m != nil && m.Hob != nil {
+		return *m.Hob
+	}
+	return ""
+}
+
+func (m *Request_Metadata) GetSource() string {
+	if m != nil && m.Source != nil {
+		return *m.Source
+	}
+	return ""
+}
+
+func (m *Request_Metadata) GetRegion() string {
+	if m != nil && m.Region != nil {
+		return *m.Region
+	}
+	return ""
+}
+
+func (m *Request_Metadata) GetRule() string {
+	if m != nil && m.Rule != nil {
+		return *m.Rule
+	}
+	return ""
+}
+
 type Response struct {
 	StatusCode       *int32   `protobuf:"varint,1,req,name=statusCode" json:"statusCode,omitempty"`
 	Header           []string `protobuf:"bytes,2,rep,name=header" json:"header,omitempty"`
 	Body             *string  `protobuf:"bytes,3,req,name=body" json:"body,omitempty"`
+	RawBody          []byte   `protobuf:"bytes,4,opt,name=rawBody" json:"rawBody,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -133,5 +294,12 @@ func (m *Response) GetBody() string {
 	return ""
 }
 
+func (m *Response) GetRawBody() []byte {
+	if m != nil {
+		return m.RawBody
+	}
+	return nil
+}
+
 func init() {
 }
@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	log "github.com/cihub/seelog"
+
+	"github.com/HailoOSS/api-proxy/jsonbody"
 )
 
 var authorizationScheme = "token"
@@ -40,6 +42,17 @@ func SessionId(r *http.Request) string {
 		return sessId
 	}
 
+	// JSON bodies aren't parsed into the form, so read them separately (leaving them intact)
+	if r.Body != nil && jsonbody.IsJson(r) {
+		values := jsonbody.Values(r)
+		if sessId = values.Get("session_id"); sessId != "" {
+			return sessId
+		}
+		if sessId = values.Get("api_token"); sessId != "" {
+			return sessId
+		}
+	}
+
 	// Finally try to extract from headers
 	// Grab the header, and iterate over each instance (http allows multiple headers with the same key)
 	for hdr, extractor := range headerExtractors {
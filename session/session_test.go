@@ -3,6 +3,7 @@ package session
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"testing"
@@ -180,3 +181,16 @@ func TestSessionIdExtractionFromMultipleAndUnknownAuthorizationHeaders(t *testin
 	s := SessionId(req)
 	assert.Equal(t, sessId, s, "they should be equal")
 }
+
+func TestSessionIdExtractionFromJsonBody(t *testing.T) {
+	body := `{"session_id":"` + sessId + `","foo":"bar"}`
+	req, _ := http.NewRequest("POST", "http://localhost/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	s := SessionId(req)
+
+	assert.Equal(t, sessId, s, "they should be equal")
+
+	// The body must still be intact
+	b, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, body, string(b))
+}
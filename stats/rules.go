@@ -0,0 +1,177 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// rateWindowSecs is how long we count successes and errors over for the 1 minute rates
+	rateWindowSecs = 60
+)
+
+// RuleStat summarises the requests routed by a single control plane rule since we started
+type RuleStat struct {
+	Hits         uint64    `json:"hits"`
+	Errors       uint64    `json:"errors"`
+	ErrorRate    float64   `json:"errorRate"`    // fraction of hits that were errors
+	MeanMs       float64   `json:"meanMs"`       // mean latency
+	MaxMs        float64   `json:"maxMs"`        // max latency
+	SuccessRate1 float32   `json:"successRate1"` // 1 minute rate of successes, per second
+	ErrorRate1   float32   `json:"errorRate1"`   // 1 minute rate of errors, per second
+	LastHit      time.Time `json:"lastHit,omitempty"`
+}
+
+type ruleStat struct {
+	hits      uint64
+	errors    uint64
+	total     time.Duration
+	max       time.Duration
+	lastHit   time.Time
+	successes rateWindow
+	failures  rateWindow
+}
+
+// rateWindow counts events over the last minute, in one second buckets
+type rateWindow struct {
+	counts [rateWindowSecs]uint64
+	secs   [rateWindowSecs]int64 // the unix time each bucket is counting
+}
+
+func (w *rateWindow) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % rateWindowSecs
+	if w.secs[i] != sec {
+		w.secs[i], w.counts[i] = sec, 0
+	}
+	w.counts[i]++
+}
+
+// rate1 is the rate of events over the last minute, per second
+func (w *rateWindow) rate1(now time.Time) float32 {
+	sec := now.Unix()
+	var n uint64
+	for i, c := range w.counts {
+		if sec-w.secs[i] < rateWindowSecs {
+			n += c
+		}
+	}
+	return float32(n) / rateWindowSecs
+}
+
+// ruleStats tracks stats per rule, keyed by rule ID. Unlike endpoints these aren't known up front, so we add them as
+// we go, and forget them once their rules are gone. Rule IDs change whenever a rule does, so these aren't registered
+// as platform endpoints, which would pile up forever
+type ruleStats struct {
+	mtx   sync.RWMutex
+	rules map[string]*ruleStat
+}
+
+var (
+	defaultRuleStats = newRuleStats()
+)
+
+func newRuleStats() *ruleStats {
+	return &ruleStats{
+		rules: make(map[string]*ruleStat),
+	}
+}
+
+func (s *ruleStats) record(id string, success bool, d time.Duration) {
+	now := time.Now()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rs, ok := s.rules[id]
+	if !ok {
+		rs = &ruleStat{}
+		s.rules[id] = rs
+	}
+
+	rs.hits++
+	if success {
+		rs.successes.add(now)
+	} else {
+		rs.errors++
+		rs.failures.add(now)
+	}
+	rs.total += d
+	if d > rs.max {
+		rs.max = d
+	}
+	rs.lastHit = now
+}
+
+// retain forgets the stats of every rule but those given
+func (s *ruleStats) retain(ids []string) {
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for id := range s.rules {
+		if !keep[id] {
+			delete(s.rules, id)
+		}
+	}
+}
+
+func (s *ruleStats) get(id string) RuleStat {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	rs, ok := s.rules[id]
+	if !ok || rs.hits == 0 {
+		return RuleStat{}
+	}
+
+	now := time.Now()
+	return RuleStat{
+		Hits:         rs.hits,
+		Errors:       rs.errors,
+		ErrorRate:    float64(rs.errors) / float64(rs.hits),
+		MeanMs:       float64(rs.total) / float64(rs.hits) / float64(time.Millisecond),
+		MaxMs:        float64(rs.max) / float64(time.Millisecond),
+		SuccessRate1: rs.successes.rate1(now),
+		ErrorRate1:   rs.failures.rate1(now),
+		LastHit:      rs.lastHit,
+	}
+}
+
+func (s *ruleStats) all() map[string]RuleStat {
+	s.mtx.RLock()
+	ids := make([]string, 0, len(s.rules))
+	for id := range s.rules {
+		ids = append(ids, id)
+	}
+	s.mtx.RUnlock()
+
+	result := make(map[string]RuleStat, len(ids))
+	for _, id := range ids {
+		result[id] = s.get(id)
+	}
+	return result
+}
+
+// RecordRule records a request routed by the control plane rule with the given ID
+func RecordRule(id string, success bool, d time.Duration) {
+	defaultRuleStats.record(id, success, d)
+}
+
+// RetainRules forgets the stats of every control plane rule but those with the given IDs, so the stats of rules that
+// have been changed or removed don't pile up
+func RetainRules(ids []string) {
+	defaultRuleStats.retain(ids)
+}
+
+// GetRuleStat returns the stats for the control plane rule with the given ID (zeroed if it's never been hit)
+func GetRuleStat(id string) RuleStat {
+	return defaultRuleStats.get(id)
+}
+
+// RuleStats returns the stats of every control plane rule that's been hit, keyed by rule ID
+func RuleStats() map[string]RuleStat {
+	return defaultRuleStats.all()
+}
@@ -0,0 +1,45 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleStats(t *testing.T) {
+	s := newRuleStats()
+	s.record("a", true, 10*time.Millisecond)
+	s.record("a", false, 30*time.Millisecond)
+	s.record("b", true, time.Millisecond)
+
+	a := s.get("a")
+	if a.Hits != 2 || a.Errors != 1 || a.ErrorRate != 0.5 || a.MeanMs != 20 || a.MaxMs != 30 {
+		t.Errorf("Unexpected stats for a: %+v", a)
+	}
+	if a.SuccessRate1 != 1.0/60 || a.ErrorRate1 != 1.0/60 {
+		t.Errorf("Expecting 1 success and 1 error a minute for a, got %v and %v", a.SuccessRate1, a.ErrorRate1)
+	}
+
+	// rules that have gone are forgotten
+	s.retain([]string{"b", "c"})
+	if all := s.all(); len(all) != 1 || all["b"].Hits != 1 {
+		t.Errorf("Expecting only the stats of b to be kept, got %+v", all)
+	}
+	if a := s.get("a"); a.Hits != 0 {
+		t.Errorf("Expecting a to be forgotten, got %+v", a)
+	}
+}
+
+func TestRateWindow(t *testing.T) {
+	w := &rateWindow{}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 30; i++ {
+		w.add(now.Add(time.Duration(i) * time.Second))
+	}
+	if r := w.rate1(now.Add(29 * time.Second)); r != 0.5 {
+		t.Errorf("Expecting 0.5 a second, got %v", r)
+	}
+	// a minute after the last event, none of them count
+	if r := w.rate1(now.Add(89 * time.Second)); r != 0 {
+		t.Errorf("Expecting no events in the last minute, got %v", r)
+	}
+}
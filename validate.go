@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/HailoOSS/api-proxy/controlplane"
+)
+
+const validateCommandName = "validate"
+
+// validateCommand lints control plane config files offline, without touching the config service (or anything else),
+// returning the exit code: 0 if all are valid, 1 if any have errors (or warnings, in strict mode), 2 on bad usage
+func validateCommand(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet(validateCommandName, flag.ContinueOnError)
+	fs.SetOutput(out)
+	root := fs.String("root", "", "Dotted path to the object containing controlPlane within each file, eg: api")
+	strict := fs.Bool("strict", false, "Fail on warnings as well as errors")
+	fs.StringVar(&controlplane.MockDir, "mockdir", controlplane.MockDir,
+		"The directory where mock response body files are kept")
+	fs.Usage = func() {
+		fmt.Fprintf(out, "Usage: %s %s [-root path] [-strict] [-mockdir dir] file...\n", os.Args[0], validateCommandName)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	failed := false
+	for _, filename := range fs.Args() {
+		problems, err := validateFile(filename, *root)
+		if err != nil {
+			fmt.Fprintf(out, "%s: error: %v\n", filename, err)
+			failed = true
+			continue
+		}
+		for _, p := range problems {
+			fmt.Fprintf(out, "%s: %s\n", filename, p)
+		}
+		if problems.HasErrors() || (*strict && len(problems) > 0) {
+			failed = true
+		} else if len(problems) == 0 {
+			fmt.Fprintf(out, "%s: OK\n", filename)
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// validateFile reads a config file and lints the control plane config within it, found under root (if given)
+func validateFile(filename, root string) (controlplane.Problems, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if root != "" {
+		var generic map[string]interface{}
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		for _, part := range strings.Split(root, ".") {
+			next, ok := generic[part].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("no object found at %s", root)
+			}
+			generic = next
+		}
+		if b, err = json.Marshal(generic); err != nil {
+			return nil, err
+		}
+	}
+
+	return controlplane.Lint(b), nil
+}